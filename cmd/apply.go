@@ -1,9 +1,8 @@
 package cmd
 
 import (
-	"fmt"
-
 	"github.com/spf13/cobra"
+	"github.com/techtonic-org/rf-migrate/pkg/migrate"
 )
 
 // applyCmd represents the apply command
@@ -12,19 +11,42 @@ var applyCmd = &cobra.Command{
 	Short: "Apply the current.sql migration",
 	Long: `Applies the current.sql migration file to the database.
 This is a one-time application, unlike the watch command which
-continuously applies the migration on changes.`,
+continuously applies the migration on changes.
+
+When several databases are configured (databaseUrls in the config file or
+a repeated --database-url flag), current.sql is applied to all of them concurrently.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		migrator, err := createMigrator()
+		setConfig, err := loadSetConfig()
+		if err != nil {
+			return err
+		}
+
+		if err := confirmProtected(cmd.Context(), setConfig, "apply current.sql to", true); err != nil {
+			return err
+		}
+
+		migrator, err := newMigrator(setConfig)
+		if err != nil {
+			return err
+		}
+
+		targets, err := createTargets(setConfig, migrator)
 		if err != nil {
 			return err
 		}
+		defer closeTargets(targets)
 
-		fmt.Println("Applying current.sql...")
-		if err := migrator.Apply(); err != nil {
+		logger.Info("Applying current.sql", "targets", len(targets))
+		results, err := migrator.ApplyTargets(targets)
+		if err != nil {
+			return err
+		}
+		migrator.LogResults(results)
+		if err := migrate.FailedResults(results); err != nil {
 			return err
 		}
 
-		fmt.Println("Successfully applied current.sql")
+		logger.Info("Successfully applied current.sql")
 		return nil
 	},
 }
@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"errors"
-	"fmt"
 
 	"github.com/spf13/cobra"
 )
@@ -21,17 +20,26 @@ The current.sql file is cleared after a successful commit.`,
 			return errors.New("migration name is required")
 		}
 
-		migrator, err := createMigrator()
+		setConfig, err := loadSetConfig()
 		if err != nil {
 			return err
 		}
 
-		fmt.Printf("Committing migration '%s'...\n", commitName)
+		if err := confirmProtected(cmd.Context(), setConfig, "commit current.sql to", false); err != nil {
+			return err
+		}
+
+		migrator, err := newMigrator(setConfig)
+		if err != nil {
+			return err
+		}
+
+		logger.Info("Committing migration", "name", commitName)
 		if err := migrator.Commit(commitName); err != nil {
 			return err
 		}
 
-		fmt.Println("Migration committed successfully")
+		logger.Info("Migration committed successfully")
 		return nil
 	},
 }
@@ -40,6 +48,6 @@ func init() {
 	rootCmd.AddCommand(commitCmd)
 	commitCmd.Flags().StringVarP(&commitName, "name", "n", "", "Name for the migration (required)")
 	if err := commitCmd.MarkFlagRequired("name"); err != nil {
-		fmt.Printf("Error marking flag as required: %v\n", err)
+		logger.Error("Failed to mark flag as required", "flag", "name", "error", err)
 	}
 }
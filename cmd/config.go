@@ -2,29 +2,120 @@ package cmd
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/techtonic-org/rf-migrate/pkg/config"
 )
 
 // configCmd shows the loaded configuration
 var configCmd = &cobra.Command{
 	Use:   "config",
 	Short: "Show the loaded configuration",
-	Long:  `Displays the current configuration after resolving from all sources: defaults, config file, environment variables, and CLI flags.`,
+	Long: `Displays the current configuration after resolving from all sources: defaults, config file, environment variables, and CLI flags.
+Each setting is followed by its source, such as (default), (file rfmigrate.yaml),
+(environment production in rfmigrate.yaml), (env DATABASE_URL) or (flag --migration-dir).
+Secrets are not resolved by running commands: cmd: values are shown as cmd:<masked>.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg, err := loadConfig()
+		cfg, err := loadDisplayConfig()
 		if err != nil {
 			return err
 		}
 
 		fmt.Println("Current Configuration:")
 		fmt.Println("----------------------")
-		fmt.Printf("Database URL: %s\n", cfg.DatabaseURL)
-		fmt.Printf("Migration Directory: %s\n", cfg.MigrationDir)
+		if cfg.ConfigFile != "" {
+			fmt.Printf("Config File: %s\n", cfg.ConfigFile)
+		} else {
+			fmt.Println("Config File: none found")
+		}
+		if cfg.Environment != "" {
+			fmt.Printf("Environment: %s\n", cfg.Environment)
+		}
+		switch {
+		case cfg.DatabaseURL != "":
+			fmt.Printf("Database URL: %s%s\n", config.MaskPassword(cfg.DatabaseURL), source(cfg, "databaseurl"))
+		case cfg.Connection != config.Connection{}:
+			fmt.Printf("Database Connection: %s%s\n", config.MaskPassword(cfg.Connection.String()), source(cfg, "connection"))
+		default:
+			fmt.Println("Database: from PG* environment variables, ~/.pgpass and pg_service.conf")
+		}
+		for _, url := range cfg.DatabaseURLs {
+			fmt.Printf("Additional Database URL: %s%s\n", config.MaskPassword(url), source(cfg, "databaseurls"))
+		}
+		if len(cfg.ProtectedURLs) > 0 {
+			fmt.Printf("Protected URLs: %s%s\n", strings.Join(cfg.ProtectedURLs, ", "), source(cfg, "protectedurls"))
+		}
+		if cfg.IsProtected(cfg.ConnectionString()) {
+			fmt.Printf("Protected: yes%s\n", source(cfg, "protected", "protectedurls"))
+		}
+		if cfg.DatabaseDriver != "" {
+			fmt.Printf("Database Driver: %s%s\n", cfg.DatabaseDriver, source(cfg, "databasedriver"))
+		} else {
+			fmt.Println("Database Driver: automatic (pq, or pgx for pgx:// URLs and services)")
+		}
+		fmt.Printf("Migration Directory: %s%s\n", cfg.MigrationDir, source(cfg, "migrationdir"))
+		fmt.Printf("Migration Naming: %s%s\n", cfg.MigrationNaming, source(cfg, "migrationnaming"))
+		fmt.Printf("Migrations Table: %s.%s%s\n", cfg.MigrationsSchema, cfg.MigrationsTable, source(cfg, "migrationsschema", "migrationstable"))
+
+		names := make([]string, 0, len(cfg.Placeholders))
+		for name := range cfg.Placeholders {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("Placeholder ${%s}: %s%s\n", name, cfg.Placeholders[name], source(cfg, "placeholders."+name))
+		}
+		for _, command := range cfg.Hooks.PreApply {
+			fmt.Printf("Pre-apply Hook: %s%s\n", command, source(cfg, "hooks.preapply"))
+		}
+		for _, command := range cfg.Hooks.PostApply {
+			fmt.Printf("Post-apply Hook: %s%s\n", command, source(cfg, "hooks.postapply"))
+		}
+
+		order, err := cfg.SetOrder()
+		if err != nil {
+			return err
+		}
+		for _, name := range order {
+			setConfig, err := cfg.ForSet(name)
+			if err != nil {
+				return err
+			}
+			key := "sets." + strings.ToLower(name)
+			fmt.Printf("\nSet: %s%s\n", name, source(cfg, key))
+			fmt.Printf("  Migration Directory: %s\n", setConfig.MigrationDir)
+			fmt.Printf("  Migrations Table: %s.%s\n", setConfig.MigrationsSchema, setConfig.MigrationsTable)
+			if dependsOn := cfg.Sets[name].DependsOn; len(dependsOn) > 0 {
+				fmt.Printf("  Depends On: %s\n", strings.Join(dependsOn, ", "))
+			}
+		}
 		return nil
 	},
 }
 
+// source describes where the settings with the given keys came from, e.g.
+// " (file rfmigrate.yaml)" or " (env DATABASE_URL, default)". A key also matches
+// the nested settings below it, so "connection" matches "connection.host".
+func source(cfg *config.Config, keys ...string) string {
+	var sources []string
+	seen := make(map[string]bool)
+	for setting, from := range cfg.Sources {
+		for _, key := range keys {
+			if (setting == key || strings.HasPrefix(setting, key+".")) && !seen[from] {
+				seen[from] = true
+				sources = append(sources, from)
+			}
+		}
+	}
+	if len(sources) == 0 {
+		return ""
+	}
+	sort.Strings(sources)
+	return " (" + strings.Join(sources, ", ") + ")"
+}
+
 func init() {
 	rootCmd.AddCommand(configCmd)
 }
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"github.com/techtonic-org/rf-migrate/pkg/config"
+	"github.com/techtonic-org/rf-migrate/pkg/db"
+)
+
+// setDependencies looks up migrations of other sets in their tracking tables
+type setDependencies struct {
+	cfg *config.Config
+	dbs map[string]db.DB
+}
+
+// newSetDependencies creates a dependency checker for the configured sets
+func newSetDependencies(cfg *config.Config) *setDependencies {
+	return &setDependencies{cfg: cfg, dbs: make(map[string]db.DB)}
+}
+
+// IsApplied reports whether a migration of the given set has been applied.
+// The set's history is only read: a set whose tracking table does not exist
+// yet has applied nothing.
+func (d *setDependencies) IsApplied(set string, fileName string) (bool, error) {
+	database, ok := d.dbs[set]
+	if !ok {
+		setConfig, err := d.cfg.ForSetReadOnly(set)
+		if err != nil {
+			return false, err
+		}
+
+		database, err = openDatabase(setConfig.ConnectionString(), setConfig)
+		if err != nil {
+			return false, err
+		}
+		d.dbs[set] = database
+	}
+
+	migrations, err := database.GetAppliedMigrations()
+	if db.IsMissingTable(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, migration := range migrations {
+		if migration.FileName == fileName {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Close closes the connections opened to other sets' tracking tables
+func (d *setDependencies) Close() {
+	for _, database := range d.dbs {
+		database.Close() //nolint:errcheck
+	}
+}
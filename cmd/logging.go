@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+var (
+	// Used for flags
+	logFormat string
+	logLevel  string
+)
+
+// logger receives the CLI's progress and error events; it is configured from
+// --log-format and --log-level before a command runs
+var logger = newTextLogger(os.Stdout, slog.LevelInfo)
+
+// setupLogger configures the CLI logger from the logging flags
+func setupLogger() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		return fmt.Errorf("invalid --log-level %q (use debug, info, warn or error)", logLevel)
+	}
+
+	switch strings.ToLower(logFormat) {
+	case "text":
+		logger = newTextLogger(os.Stdout, level)
+	case "json":
+		logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+	default:
+		return fmt.Errorf("invalid --log-format %q (use text or json)", logFormat)
+	}
+
+	return nil
+}
+
+// newTextLogger creates a logfmt logger without timestamps, which are noise on a terminal
+func newTextLogger(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if len(groups) == 0 && attr.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return attr
+		},
+	}))
+}
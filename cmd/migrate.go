@@ -1,34 +1,75 @@
 package cmd
 
 import (
-	"fmt"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
+var dependencyWait time.Duration
+
 // migrateCmd represents the migrate command
 var migrateCmd = &cobra.Command{
 	Use:   "migrate",
 	Short: "Apply all unapplied migrations",
 	Long: `Applies all migration files from the migrations directory that have not yet been applied.
 This command is typically used in production or staging environments to bring
-the database schema up to date.`,
+the database schema up to date.
+
+When several migration sets are configured and --set is not given, every set is
+migrated, each after the sets it depends on.
+
+A migration can require a migration of another set with a header such as
+  --! requires: accounts/20240101120000_create_accounts.sql
+Migrate fails if the requirement has not been applied, or with --wait-for-dependencies
+waits up to the given duration for a separately running migrate to apply it.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		migrator, err := createMigrator()
+		cfg, err := loadConfig()
 		if err != nil {
 			return err
 		}
 
-		fmt.Println("Applying migrations...")
-		if err := migrator.Migrate(); err != nil {
-			return err
+		sets := []string{setName}
+		if setName == "" && len(cfg.Sets) > 0 {
+			if sets, err = cfg.SetOrder(); err != nil {
+				return err
+			}
+		}
+
+		dependencies := newSetDependencies(cfg)
+		defer dependencies.Close()
+
+		for _, set := range sets {
+			setConfig, err := cfg.ForSet(set)
+			if err != nil {
+				return err
+			}
+
+			migrator, err := newMigrator(setConfig)
+			if err != nil {
+				return err
+			}
+			migrator.Dependencies = dependencies
+			migrator.DependencyWait = dependencyWait
+
+			if set == "" {
+				logger.Info("Applying migrations")
+			} else {
+				logger.Info("Applying migrations", "set", set)
+			}
+			err = migrator.Migrate()
+			migrator.DB.Close() //nolint:errcheck
+			if err != nil {
+				return err
+			}
 		}
 
-		fmt.Println("All migrations applied successfully")
+		logger.Info("All migrations applied successfully")
 		return nil
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(migrateCmd)
+	migrateCmd.Flags().DurationVar(&dependencyWait, "wait-for-dependencies", 0, "How long to wait for required migrations of other sets, e.g. 5m (default: fail immediately)")
 }
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/techtonic-org/rf-migrate/pkg/config"
+)
+
+// confirmDatabase is the database name given with --confirm-database
+var confirmDatabase string
+
+// confirmProtected asks for the name of every protected database of a set's
+// configuration that the command would touch before it does so. The primary database is always checked, and
+// the additional databases of databaseUrls as well when allTargets is set.
+// Without a terminal the name must be given with --confirm-database.
+// Waiting for the answer stops when ctx is done.
+func confirmProtected(ctx context.Context, setConfig *config.Config, action string, allTargets bool) error {
+	urls := setConfig.TargetURLs()
+	if !allTargets {
+		urls = urls[:1]
+	}
+
+	for _, connection := range urls {
+		if !setConfig.IsProtected(connection) {
+			continue
+		}
+
+		// The name to type; the environment stands in when the database is not named
+		name := config.DatabaseName(connection)
+		if name == "" {
+			name = setConfig.Environment
+		}
+		if name == "" {
+			return fmt.Errorf("refusing to %s a protected database without a name; set the database name in the connection settings", action)
+		}
+
+		if confirmDatabase == name {
+			continue
+		}
+		if confirmDatabase != "" {
+			return fmt.Errorf("refusing to %s protected database %s: --confirm-database names %s", action, name, confirmDatabase)
+		}
+		if !isTerminal(os.Stdin) {
+			return fmt.Errorf("refusing to %s protected database %s; pass --confirm-database %s to confirm", action, name, name)
+		}
+
+		// The prompt goes to stderr so it stays out of redirected output
+		fmt.Fprintf(os.Stderr, "Database %s is protected. Type its name to %s it: ", name, action)
+		line, err := readLine(ctx)
+		if ctx.Err() != nil {
+			return fmt.Errorf("stopped waiting for confirmation of protected database %s: %w", name, ctx.Err())
+		}
+		if err != nil || strings.TrimSpace(line) != name {
+			return fmt.Errorf("refusing to %s protected database %s: confirmation did not match", action, name)
+		}
+	}
+
+	return nil
+}
+
+// readLine reads a line from stdin, or returns early once ctx is done.
+// The read itself cannot be interrupted and is left to finish in the background.
+func readLine(ctx context.Context) (string, error) {
+	type result struct {
+		line string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		done <- result{line, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case r := <-done:
+		return r.line, r.err
+	}
+}
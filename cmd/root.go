@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"net/url"
 	"os"
+	"os/signal"
 
 	"github.com/spf13/cobra"
 	"github.com/techtonic-org/rf-migrate/pkg/config"
@@ -10,12 +13,20 @@ import (
 	"github.com/techtonic-org/rf-migrate/pkg/migrate"
 )
 
+// Version is the rf-migrate version, recorded with every applied migration
+var Version = "dev"
+
 var (
 	// Used for flags
-	cfgFile      string
-	databaseURL  string
-	migrationDir string
-	showVersion  bool
+	cfgFile          string
+	databaseURLs     []string
+	databaseDriver   string
+	migrationDir     string
+	migrationsSchema string
+	migrationsTable  string
+	setName          string
+	environment      string
+	showVersion      bool
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -24,12 +35,22 @@ var rootCmd = &cobra.Command{
 	Short: "A simple database migration tool",
 	Long: `rf-migrate is a CLI tool for managing database migrations.
 It allows you to develop, apply, and track database schema changes.`,
+	// Errors are reported through the logger so JSON output stays parseable
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return setupLogger()
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
+// An interrupt cancels running statements, so migrations are rolled back on the server.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+	if err != nil {
+		logger.Error("Command failed", "error", err)
 		os.Exit(1)
 	}
 }
@@ -39,8 +60,16 @@ func init() {
 
 	// Global flags
 	rootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "", "config file (default is ./rfmigrate.yaml)")
-	rootCmd.PersistentFlags().StringVar(&databaseURL, "database-url", "", "Database connection URL")
+	rootCmd.PersistentFlags().StringArrayVar(&databaseURLs, "database-url", nil, "Database connection URL (repeat to apply current.sql to several databases)")
+	rootCmd.PersistentFlags().StringVar(&databaseDriver, "database-driver", "", "PostgreSQL driver: pq or pgx (default pq, or pgx where needed)")
 	rootCmd.PersistentFlags().StringVar(&migrationDir, "migration-dir", "", "Directory for migration files")
+	rootCmd.PersistentFlags().StringVar(&migrationsSchema, "migrations-schema", "", "Schema of the table that records applied migrations (default rf_migrate)")
+	rootCmd.PersistentFlags().StringVar(&migrationsTable, "migrations-table", "", "Table that records applied migrations (default migrations)")
+	rootCmd.PersistentFlags().StringVar(&environment, "env", "", "Environment from the config file to use (default $RF_ENV)")
+	rootCmd.PersistentFlags().StringVar(&confirmDatabase, "confirm-database", "", "Name of the protected database to confirm apply, watch, commit or uncommit against")
+	rootCmd.PersistentFlags().StringVar(&setName, "set", "", "Migration set to use when several sets are configured")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "Log format: text or json")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Log level: debug, info, warn or error")
 	rootCmd.PersistentFlags().BoolVarP(&showVersion, "version", "v", false, "Show version information")
 }
 
@@ -51,35 +80,147 @@ func initConfig() {
 
 // loadConfig loads configuration and applies CLI flag overrides
 func loadConfig() (*config.Config, error) {
-	cfg, err := config.LoadConfig(cfgFile)
+	return loadConfigWith(config.LoadConfigForEnvironment, config.ResolveSecret)
+}
+
+// loadDisplayConfig loads configuration like loadConfig without running cmd: secrets
+func loadDisplayConfig() (*config.Config, error) {
+	return loadConfigWith(config.LoadConfigForDisplay, config.ResolveSecretForDisplay)
+}
+
+// loadConfigWith loads configuration with load and applies CLI flag overrides,
+// resolving secrets given in flags with resolveSecret
+func loadConfigWith(load func(configPath, environment string) (*config.Config, error), resolveSecret func(string) (string, error)) (*config.Config, error) {
+	cfg, err := load(cfgFile, environment)
 	if err != nil {
 		return nil, err
 	}
 
 	// Override with CLI flags if provided
-	if databaseURL != "" {
-		cfg.DatabaseURL = databaseURL
+	if len(databaseURLs) > 0 {
+		urls := make([]string, len(databaseURLs))
+		for i, url := range databaseURLs {
+			resolved, err := resolveSecret(url)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve secret --database-url: %w", err)
+			}
+			urls[i] = resolved
+		}
+		cfg.DatabaseURL = urls[0]
+		cfg.DatabaseURLs = urls[1:]
+		cfg.Sources["databaseurl"] = "flag --database-url"
+		cfg.Sources["databaseurls"] = "flag --database-url"
+	}
+	if databaseDriver != "" {
+		cfg.DatabaseDriver = databaseDriver
+		cfg.Sources["databasedriver"] = "flag --database-driver"
 	}
 	if migrationDir != "" {
 		cfg.MigrationDir = migrationDir
+		cfg.Sources["migrationdir"] = "flag --migration-dir"
+	}
+	if migrationsSchema != "" {
+		cfg.MigrationsSchema = migrationsSchema
+		cfg.Sources["migrationsschema"] = "flag --migrations-schema"
+	}
+	if migrationsTable != "" {
+		cfg.MigrationsTable = migrationsTable
+		cfg.Sources["migrationstable"] = "flag --migrations-table"
+	}
+
+	// Flags are held to the same rules as the config
+	if err := cfg.Validate(); err != nil {
+		return nil, err
 	}
 
 	return cfg, nil
 }
 
-// createMigrator creates a new migrator instance
-func createMigrator() (*migrate.Migrator, error) {
+// loadSetConfig loads configuration for the set selected with --set
+func loadSetConfig() (*config.Config, error) {
 	cfg, err := loadConfig()
 	if err != nil {
 		return nil, err
 	}
 
+	return cfg.ForSet(setName)
+}
+
+// newMigrator connects to the database and creates a migrator for a set's configuration
+func newMigrator(cfg *config.Config) (*migrate.Migrator, error) {
+	if err := migrate.ValidateNaming(cfg.MigrationNaming); err != nil {
+		return nil, err
+	}
+
 	// Connect to database
-	database, err := db.NewPostgresDB(cfg.DatabaseURL)
+	database, err := openDatabase(cfg.ConnectionString(), cfg)
 	if err != nil {
 		return nil, err
 	}
 
 	// Create migrator
-	return migrate.NewMigrator(database, cfg.MigrationDir)
+	return migrate.New(database,
+		migrate.WithDir(cfg.MigrationDir),
+		migrate.WithNaming(cfg.MigrationNaming),
+		migrate.WithToolVersion(Version),
+		migrate.WithLogger(logger),
+		migrate.WithContext(rootCmd.Context()),
+		migrate.WithPlaceholders(cfg.Placeholders),
+		migrate.WithHooks(migrate.Hooks{PreApply: cfg.Hooks.PreApply, PostApply: cfg.Hooks.PostApply}),
+	)
+}
+
+// createTargets connects to every database of a set's configuration that
+// current.sql is applied to. The migrator's own database is always the first
+// target; the others are closed with closeTargets.
+func createTargets(cfg *config.Config, migrator *migrate.Migrator) ([]migrate.Target, error) {
+	urls := cfg.TargetURLs()
+	targets := []migrate.Target{{Name: targetName(urls[0], 0), DB: migrator.DB}}
+	for i, rawURL := range urls[1:] {
+		name := targetName(rawURL, i+1)
+		database, err := openDatabase(rawURL, cfg)
+		if err != nil {
+			closeTargets(targets)
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		targets = append(targets, migrate.Target{Name: name, DB: database})
+	}
+
+	return targets, nil
+}
+
+// closeTargets closes the databases opened by createTargets, leaving the
+// migrator's own database open
+func closeTargets(targets []migrate.Target) {
+	for _, target := range targets[1:] {
+		if err := target.DB.Close(); err != nil {
+			logger.Error("Failed to close database", "target", target.Name, "error", err)
+		}
+	}
+}
+
+// openDatabase connects to a database with the configured driver and tracking table.
+// Statements are cancelled on interrupt and server notices are logged at debug level.
+func openDatabase(rawURL string, cfg *config.Config) (db.DB, error) {
+	return db.Open(rawURL, trackingTable(cfg),
+		db.WithDriver(cfg.DatabaseDriver),
+		db.WithContext(rootCmd.Context()),
+		db.WithNoticeHandler(func(notice db.Notice) {
+			logger.Debug("Database notice", "severity", notice.Severity, "message", notice.Message)
+		}),
+	)
+}
+
+// trackingTable returns the configured table that records applied migrations
+func trackingTable(cfg *config.Config) db.TrackingTable {
+	return db.TrackingTable{Schema: cfg.MigrationsSchema, Name: cfg.MigrationsTable}
+}
+
+// targetName returns a short, credential-free name for a database URL
+func targetName(rawURL string, index int) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return fmt.Sprintf("database #%d", index+1)
+	}
+	return u.Host + u.Path
 }
@@ -0,0 +1,19 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package cmd
+
+import (
+	"errors"
+	"os"
+)
+
+// enableKeyMode is not supported on this platform; keys must be followed by Enter
+func enableKeyMode(fd int) (restore func() error, err error) {
+	return nil, errors.New("single key input is not supported on this platform")
+}
+
+// isTerminal reports whether f is a character device, the closest check available here
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
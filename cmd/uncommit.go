@@ -1,33 +1,52 @@
 package cmd
 
 import (
-	"fmt"
-
 	"github.com/spf13/cobra"
 )
 
+var (
+	uncommitSteps int
+	uncommitForce bool
+)
+
 // uncommitCmd represents the uncommit command
 var uncommitCmd = &cobra.Command{
 	Use:   "uncommit",
 	Short: "Uncommit the last migration",
 	Long: `Removes the last migration from the migrations table,
-deletes the migration file, and puts its content back into current.sql.`,
+deletes the migration file, and puts its content back into current.sql.
+
+With --steps N the last N migrations are folded back into current.sql, oldest first.
+Each migration must be the newest file on disk and unchanged since it was applied.
+If current.sql has content, uncommit refuses to run unless --force is given,
+in which case the existing content is kept after the uncommitted migrations.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		migrator, err := createMigrator()
+		setConfig, err := loadSetConfig()
+		if err != nil {
+			return err
+		}
+
+		if err := confirmProtected(cmd.Context(), setConfig, "uncommit migrations from", false); err != nil {
+			return err
+		}
+
+		migrator, err := newMigrator(setConfig)
 		if err != nil {
 			return err
 		}
 
-		fmt.Println("Uncommitting the last migration...")
-		if err := migrator.Uncommit(); err != nil {
+		logger.Info("Uncommitting migrations", "steps", uncommitSteps)
+		if err := migrator.Uncommit(uncommitSteps, uncommitForce); err != nil {
 			return err
 		}
 
-		fmt.Println("Last migration uncommitted successfully")
+		logger.Info("Uncommitted successfully")
 		return nil
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(uncommitCmd)
+	uncommitCmd.Flags().IntVar(&uncommitSteps, "steps", 1, "Number of migrations to uncommit")
+	uncommitCmd.Flags().BoolVar(&uncommitForce, "force", false, "Uncommit even if current.sql has content")
 }
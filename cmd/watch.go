@@ -1,28 +1,216 @@
 package cmd
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"os"
+	"strings"
+	"sync"
 
 	"github.com/spf13/cobra"
+	"github.com/techtonic-org/rf-migrate/pkg/migrate"
 )
 
+// watchKeysHelp lists the single-key commands available while watching
+const watchKeysHelp = "Keys: r reapply, c commit, s status, d schema diff, q quit"
+
 // watchCmd represents the watch command
 var watchCmd = &cobra.Command{
 	Use:   "watch",
 	Short: "Watch current.sql and reapply on changes",
 	Long: `Watches the current.sql file and reapplies it to the database whenever it changes.
-This is useful during development to test your migrations without having to manually reapply them.`,
+This is useful during development to test your migrations without having to manually reapply them.
+
+When several databases are configured (databaseUrls in the config file or
+a repeated --database-url flag), each save is applied to all of them concurrently.
+
+While watching, single keys control the session:
+  r  reapply current.sql now
+  c  commit current.sql (prompts for a migration name)
+  s  show applied and pending migrations
+  d  show the schema changes since before the last apply
+  q  quit`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		migrator, err := createMigrator()
+		ctx, quit := context.WithCancel(cmd.Context())
+		defer quit()
+
+		setConfig, err := loadSetConfig()
+		if err != nil {
+			return err
+		}
+
+		if err := confirmProtected(ctx, setConfig, "watch current.sql on", true); err != nil {
+			return err
+		}
+
+		migrator, err := newMigrator(setConfig)
 		if err != nil {
 			return err
 		}
 
-		fmt.Println("Watching current.sql for changes...")
-		return migrator.Watch()
+		targets, err := createTargets(setConfig, migrator)
+		if err != nil {
+			return err
+		}
+		defer closeTargets(targets)
+
+		keys := newKeyboard()
+		defer keys.disable()
+
+		actions := make(chan func())
+		go keys.run(ctx, quit, actions, migrator, targets)
+
+		logger.Info("Watching current.sql for changes", "targets", len(targets))
+		fmt.Println(watchKeysHelp)
+		return migrator.WatchTargets(ctx, targets, actions)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(watchCmd)
 }
+
+// keyboard reads single-key watch commands from stdin
+type keyboard struct {
+	reader *bufio.Reader
+
+	mu      sync.Mutex
+	restore func() error // Restores the terminal; nil when not in key mode
+}
+
+// newKeyboard creates a keyboard and switches the terminal to key mode when possible.
+// When stdin is not a terminal, keys are still read but must be followed by Enter.
+func newKeyboard() *keyboard {
+	k := &keyboard{reader: bufio.NewReader(os.Stdin)}
+	k.enable()
+	return k
+}
+
+// enable puts the terminal in key mode
+func (k *keyboard) enable() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.restore != nil {
+		return
+	}
+	if restore, err := enableKeyMode(int(os.Stdin.Fd())); err == nil {
+		k.restore = restore
+	}
+}
+
+// disable restores the terminal to its original mode
+func (k *keyboard) disable() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.restore == nil {
+		return
+	}
+	if err := k.restore(); err != nil {
+		logger.Error("Failed to restore terminal", "error", err)
+	}
+	k.restore = nil
+}
+
+// prompt reads a line of input with the terminal temporarily back in line mode
+func (k *keyboard) prompt(label string) (string, error) {
+	k.disable()
+	defer k.enable()
+
+	fmt.Print(label)
+	line, err := k.reader.ReadString('\n')
+	return strings.TrimSpace(line), err
+}
+
+// run reads keys until stdin is closed or ctx is cancelled, sending the resulting
+// work to the watch loop so it never runs while current.sql is being applied
+func (k *keyboard) run(ctx context.Context, quit func(), actions chan<- func(), migrator *migrate.Migrator, targets []migrate.Target) {
+	send := func(action func()) {
+		select {
+		case actions <- action:
+		case <-ctx.Done():
+		}
+	}
+
+	for {
+		key, err := k.reader.ReadByte()
+		if err != nil {
+			return // stdin closed, keep watching without key commands
+		}
+
+		switch key {
+		case 'r':
+			send(func() {
+				logger.Info("Reapplying current.sql")
+				if err := migrator.Reapply(targets); err != nil {
+					logger.Error("Failed to reapply current.sql", "error", err)
+				}
+			})
+		case 'c':
+			name, err := k.prompt("Migration name: ")
+			if err != nil || name == "" {
+				fmt.Println("Commit cancelled")
+				continue
+			}
+			send(func() {
+				if err := migrator.Commit(name); err != nil {
+					logger.Error("Failed to commit migration", "name", name, "error", err)
+				}
+			})
+		case 's':
+			send(func() { printStatus(migrator) })
+		case 'd':
+			send(func() { printSchemaDiff(migrator) })
+		case 'q':
+			logger.Info("Stopping watch")
+			quit()
+			return
+		case '\n', '\r', ' ':
+			// Ignore line endings when keys are followed by Enter
+		default:
+			fmt.Println(watchKeysHelp)
+		}
+	}
+}
+
+// printStatus prints applied and pending migrations and the size of current.sql
+func printStatus(migrator *migrate.Migrator) {
+	status, err := migrator.Status()
+	if err != nil {
+		logger.Error("Failed to get status", "error", err)
+		return
+	}
+
+	fmt.Printf("Applied migrations: %d\n", len(status.Applied))
+	if len(status.Applied) > 0 {
+		last := status.Applied[len(status.Applied)-1]
+		fmt.Printf("  last: %s (%s)\n", last.FileName, last.Date.Format("2006-01-02 15:04:05"))
+	}
+	fmt.Printf("Pending migrations: %d\n", len(status.Pending))
+	for _, file := range status.Pending {
+		fmt.Printf("  %s\n", file)
+	}
+	fmt.Printf("current.sql: %d bytes\n", status.CurrentSize)
+}
+
+// printSchemaDiff prints the schema objects added and removed since before the last apply
+func printSchemaDiff(migrator *migrate.Migrator) {
+	added, removed, err := migrator.SchemaDiff()
+	if err != nil {
+		logger.Error("Failed to diff schema", "error", err)
+		return
+	}
+
+	if len(added) == 0 && len(removed) == 0 {
+		fmt.Println("No schema changes since before the last apply")
+		return
+	}
+	for _, line := range removed {
+		fmt.Printf("- %s\n", line)
+	}
+	for _, line := range added {
+		fmt.Printf("+ %s\n", line)
+	}
+}
@@ -19,5 +19,6 @@ func main() {
 		}
 	}
 
+	cmd.Version = version
 	cmd.Execute()
 }
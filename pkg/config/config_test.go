@@ -0,0 +1,27 @@
+package config
+
+import "testing"
+
+func TestIsProtected(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        Config
+		connection string
+		want       bool
+	}{
+		{name: "unprotected", connection: "postgres://db/prod", want: false},
+		{name: "protected flag", cfg: Config{Protected: true}, connection: "postgres://localhost/dev", want: true},
+		{name: "pattern match", cfg: Config{ProtectedURLs: []string{"*prod*"}}, connection: "postgres://db.prod.internal/app", want: true},
+		{name: "pattern mismatch", cfg: Config{ProtectedURLs: []string{"*prod*"}}, connection: "postgres://localhost/dev", want: false},
+		{name: "anchored pattern", cfg: Config{ProtectedURLs: []string{"postgres://db/*"}}, connection: "pgx://postgres://db/app", want: false},
+		{name: "regexp characters are literal", cfg: Config{ProtectedURLs: []string{"*db.prod*"}}, connection: "postgres://dbxprod/app", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.IsProtected(tt.connection); got != tt.want {
+				t.Errorf("IsProtected(%q) = %v, want %v", tt.connection, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// keywordDBName matches the database name of a keyword/value connection string
+var keywordDBName = regexp.MustCompile(`dbname\s*=\s*('(?:[^'\\]|\\.)*'|\S+)`)
+
+// Connection holds PostgreSQL connection settings as separate fields, an
+// alternative to databaseUrl that keeps secrets out of URLs. Empty fields are
+// left to the driver, which falls back to the standard PG* environment
+// variables (PGHOST, PGUSER, PGPASSWORD, PGSERVICE, ...), ~/.pgpass and
+// pg_service.conf, like psql does.
+type Connection struct {
+	Host        string `mapstructure:"host"`
+	Port        int    `mapstructure:"port"`
+	User        string `mapstructure:"user"`
+	Password    string `mapstructure:"password"`
+	DBName      string `mapstructure:"dbname"`
+	SSLMode     string `mapstructure:"sslmode"`
+	SSLRootCert string `mapstructure:"sslrootcert"`
+	SSLCert     string `mapstructure:"sslcert"`
+	SSLKey      string `mapstructure:"sslkey"`
+	Service     string `mapstructure:"service"` // Section of pg_service.conf to read defaults from
+}
+
+// String returns the set fields as a keyword/value connection string,
+// e.g. "host=db.internal dbname=app sslmode=verify-full"
+func (c Connection) String() string {
+	settings := []struct{ key, value string }{
+		{"service", c.Service},
+		{"host", c.Host},
+		{"port", ""},
+		{"user", c.User},
+		{"password", c.Password},
+		{"dbname", c.DBName},
+		{"sslmode", c.SSLMode},
+		{"sslrootcert", c.SSLRootCert},
+		{"sslcert", c.SSLCert},
+		{"sslkey", c.SSLKey},
+	}
+	if c.Port != 0 {
+		settings[2].value = strconv.Itoa(c.Port)
+	}
+
+	var parts []string
+	for _, setting := range settings {
+		if setting.value != "" {
+			parts = append(parts, setting.key+"="+quoteConnValue(setting.value))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// quoteConnValue quotes a connection string value when it needs quoting
+func quoteConnValue(value string) string {
+	if !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+	return "'" + escaped + "'"
+}
+
+// DatabaseName returns the database a connection URL or keyword/value
+// connection string connects to: the path of a URL, which is the file of a
+// sqlite:// URL, or the dbname setting. Without one it is PGDATABASE, which
+// may be empty.
+func DatabaseName(connection string) string {
+	if strings.Contains(connection, "://") {
+		u, err := url.Parse(connection)
+		if err == nil {
+			if u.Scheme == "sqlite" {
+				return u.Host + u.Path
+			}
+			if name := strings.TrimPrefix(u.Path, "/"); name != "" {
+				return name
+			}
+		}
+	} else if match := keywordDBName.FindStringSubmatch(connection); match != nil {
+		return unquoteConnValue(match[1])
+	}
+	return os.Getenv("PGDATABASE")
+}
+
+// unquoteConnValue reverses quoteConnValue
+func unquoteConnValue(value string) string {
+	if len(value) < 2 || value[0] != '\'' || value[len(value)-1] != '\'' {
+		return value
+	}
+	return strings.NewReplacer(`\\`, `\`, `\'`, `'`).Replace(value[1 : len(value)-1])
+}
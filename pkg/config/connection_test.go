@@ -0,0 +1,56 @@
+package config
+
+import "testing"
+
+func TestConnectionString(t *testing.T) {
+	tests := []struct {
+		name       string
+		connection Connection
+		want       string
+	}{
+		{name: "empty", want: ""},
+		{
+			name:       "fields in order",
+			connection: Connection{DBName: "app", Host: "db.internal", Port: 5433, User: "app", SSLMode: "verify-full"},
+			want:       "host=db.internal port=5433 user=app dbname=app sslmode=verify-full",
+		},
+		{name: "quoted", connection: Connection{Password: `it's a \ secret`}, want: `password='it\'s a \\ secret'`},
+		{name: "service", connection: Connection{Service: "prod", DBName: "app"}, want: "service=prod dbname=app"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.connection.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDatabaseName(t *testing.T) {
+	t.Setenv("PGDATABASE", "fromenv")
+
+	tests := []struct {
+		connection string
+		want       string
+	}{
+		{"postgres://app:pw@db:5432/orders?sslmode=disable", "orders"},
+		{"pgx://db/orders", "orders"},
+		{"mysql://root@localhost:3306/shop", "shop"},
+		{"sqlite://dev.db", "dev.db"},
+		{"sqlite:///var/lib/app/app.db", "/var/lib/app/app.db"},
+		{"host=db dbname=orders", "orders"},
+		{"host=db dbname='my orders'", "my orders"},
+		{"postgres://db", "fromenv"},
+		{"host=db", "fromenv"},
+		{"", "fromenv"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.connection, func(t *testing.T) {
+			if got := DatabaseName(tt.connection); got != tt.want {
+				t.Errorf("DatabaseName(%q) = %q, want %q", tt.connection, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// environmentsKey is the config file section holding named environments, such
+// as local, staging and production, each overriding any top-level settings
+const environmentsKey = "environments"
+
+// selectEnvironment merges the settings of the named environment, a lowercase
+// name, over the top-level settings in v, below environment variables and flags.
+// Every environment is checked for unknown keys first. It returns the keys of
+// the merged settings.
+func selectEnvironment(v *viper.Viper, name string) ([]string, error) {
+	environments := v.GetStringMap(environmentsKey)
+	names := make([]string, 0, len(environments))
+	for environment := range environments {
+		names = append(names, environment)
+	}
+	sort.Strings(names)
+
+	for _, environment := range names {
+		if err := validateEnvironment(v, environment); err != nil {
+			return nil, err
+		}
+	}
+
+	if name == "" {
+		return nil, nil
+	}
+
+	if _, ok := environments[name]; !ok {
+		if len(environments) == 0 {
+			return nil, fmt.Errorf("environment %s is not defined: the config file has no environments", name)
+		}
+		return nil, fmt.Errorf("environment %s is not defined, expected one of: %s", name, strings.Join(names, ", "))
+	}
+
+	// An environment without settings selects the top-level ones
+	sub := v.Sub(environmentsKey + "." + name)
+	if sub == nil {
+		return nil, nil
+	}
+
+	// An environment connecting field by field replaces a top-level URL
+	settings := sub.AllSettings()
+	keys := sub.AllKeys()
+	if _, ok := settings["connection"]; ok && !sub.IsSet("databaseUrl") {
+		settings["databaseurl"] = ""
+		keys = append(keys, "databaseurl")
+	}
+
+	if err := v.MergeConfigMap(settings); err != nil {
+		return nil, fmt.Errorf("failed to merge environment %s: %w", name, err)
+	}
+	return keys, nil
+}
+
+// validateEnvironment rejects unknown keys and nested environments in an environment
+func validateEnvironment(v *viper.Viper, name string) error {
+	sub := v.Sub(environmentsKey + "." + name)
+	if sub == nil {
+		return nil
+	}
+
+	// Checked in the raw config, as decoding drops an empty nested section
+	if sub.InConfig(environmentsKey) {
+		return fmt.Errorf("environment %s must not define environments", name)
+	}
+
+	var settings Config
+	if err := sub.UnmarshalExact(&settings); err != nil {
+		return fmt.Errorf("unable to decode environment %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// environmentsYAML defines the environments used by the tests; {dir} is replaced
+// with a temporary directory
+const environmentsYAML = `
+databaseUrl: postgres://localhost/dev
+migrationDir: {dir}/migrations
+placeholders:
+  appRole: app_dev
+  schema: public
+hooks:
+  postApply: ["echo applied"]
+environments:
+  staging:
+    databaseUrl: postgres://staging/app
+    migrationsTable: staged
+    placeholders:
+      appRole: app_staging
+    hooks:
+      preApply: ["./backup.sh"]
+  production:
+    protected: true
+    connection:
+      host: prod.internal
+      dbname: app
+      password: file:{dir}/password
+  broken:
+    connection:
+      password: file:{dir}/missing
+  empty: {}
+`
+
+// writeEnvironmentsConfig writes environmentsYAML and the password it refers to,
+// and returns the config file path
+func writeEnvironmentsConfig(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "password"), []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "rfmigrate.yaml")
+	if err := os.WriteFile(path, []byte(strings.ReplaceAll(environmentsYAML, "{dir}", dir)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigForEnvironment(t *testing.T) {
+	path := writeEnvironmentsConfig(t)
+
+	tests := []struct {
+		name        string
+		environment string
+		envVars     map[string]string
+		check       func(t *testing.T, cfg *Config)
+		wantErr     string
+	}{
+		{
+			name: "no environment",
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.ConnectionString() != "postgres://localhost/dev" || cfg.Environment != "" {
+					t.Errorf("connection = %q, environment = %q", cfg.ConnectionString(), cfg.Environment)
+				}
+				if cfg.Sources["databaseurl"] != "file "+path {
+					t.Errorf("source = %q, want the file", cfg.Sources["databaseurl"])
+				}
+				if cfg.Sources["migrationstable"] != "default" {
+					t.Errorf("source = %q, want default", cfg.Sources["migrationstable"])
+				}
+			},
+		},
+		{
+			name:        "overrides top-level settings",
+			environment: "Staging",
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.DatabaseURL != "postgres://staging/app" || cfg.MigrationsTable != "staged" || cfg.Environment != "staging" {
+					t.Errorf("got %q, %q, %q", cfg.DatabaseURL, cfg.MigrationsTable, cfg.Environment)
+				}
+				if want := "environment staging in " + path; cfg.Sources["databaseurl"] != want {
+					t.Errorf("source = %q, want %q", cfg.Sources["databaseurl"], want)
+				}
+			},
+		},
+		{
+			name:        "merges placeholders and hooks",
+			environment: "staging",
+			check: func(t *testing.T, cfg *Config) {
+				want := map[string]string{"approle": "app_staging", "schema": "public"}
+				if !reflect.DeepEqual(cfg.Placeholders, want) {
+					t.Errorf("placeholders = %v, want %v", cfg.Placeholders, want)
+				}
+				if want := "environment staging in " + path; cfg.Sources["placeholders.approle"] != want {
+					t.Errorf("source = %q, want %q", cfg.Sources["placeholders.approle"], want)
+				}
+				hooks := Hooks{PreApply: []string{"./backup.sh"}, PostApply: []string{"echo applied"}}
+				if !reflect.DeepEqual(cfg.Hooks, hooks) {
+					t.Errorf("hooks = %+v, want %+v", cfg.Hooks, hooks)
+				}
+			},
+		},
+		{
+			name:    "selected with RF_ENV",
+			envVars: map[string]string{"RF_ENV": "staging"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Environment != "staging" {
+					t.Errorf("environment = %q, want staging", cfg.Environment)
+				}
+			},
+		},
+		{
+			name:        "environment variables win",
+			environment: "staging",
+			envVars:     map[string]string{"DATABASE_URL": "postgres://override/app"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.DatabaseURL != "postgres://override/app" || cfg.Sources["databaseurl"] != "env DATABASE_URL" {
+					t.Errorf("got %q from %q", cfg.DatabaseURL, cfg.Sources["databaseurl"])
+				}
+			},
+		},
+		{
+			name:        "connection replaces the top-level URL",
+			environment: "production",
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.DatabaseURL != "" {
+					t.Errorf("databaseUrl = %q, want it replaced", cfg.DatabaseURL)
+				}
+				if want := "host=prod.internal password=s3cr3t dbname=app"; cfg.ConnectionString() != want {
+					t.Errorf("connection = %q, want %q", cfg.ConnectionString(), want)
+				}
+				if !cfg.IsProtected(cfg.ConnectionString()) {
+					t.Error("production is not protected")
+				}
+			},
+		},
+		{
+			name:        "empty environment",
+			environment: "empty",
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.DatabaseURL != "postgres://localhost/dev" {
+					t.Errorf("databaseUrl = %q, want the top-level one", cfg.DatabaseURL)
+				}
+			},
+		},
+		{
+			name:        "secrets of other environments are not read",
+			environment: "staging",
+			check:       func(t *testing.T, cfg *Config) {},
+		},
+		{name: "selected secret fails", environment: "broken", wantErr: "failed to resolve secret: connection.password"},
+		{name: "unknown environment", environment: "qa", wantErr: "expected one of: broken, empty, production, staging"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("RF_ENV", "")
+			t.Setenv("DATABASE_URL", "")
+			for key, value := range tt.envVars {
+				t.Setenv(key, value)
+			}
+
+			cfg, err := LoadConfigForEnvironment(path, tt.environment)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadConfigForEnvironment() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfigForEnvironment() error = %v", err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestLoadConfigRejectsUnknownKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{name: "top level", yaml: "migrationDirr: ./db\n", wantErr: "migrationdirr"},
+		{name: "in a set", yaml: "sets:\n  a:\n    dependOn: [b]\n", wantErr: "dependon"},
+		{name: "in an environment", yaml: "environments:\n  prod:\n    databseUrl: x\n", wantErr: "environment prod"},
+		{name: "nested environments", yaml: "environments:\n  prod:\n    environments:\n      qa: {}\n", wantErr: "must not define environments"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "rfmigrate.yaml")
+			content := "migrationDir: " + filepath.Join(dir, "migrations") + "\n" + tt.yaml
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := LoadConfig(path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("LoadConfig() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
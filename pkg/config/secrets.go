@@ -0,0 +1,134 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Prefixes of config values that are resolved when the config is loaded
+const (
+	filePrefix = "file:" // file:/run/secrets/db_password reads the file
+	cmdPrefix  = "cmd:"  // cmd:pass show db/prod runs the command and reads its output
+)
+
+// redacted replaces passwords in displayed connection strings
+const redacted = "xxxxx"
+
+// MaskedCommand stands in for cmd: secrets that were not run, see LoadConfigForDisplay
+const MaskedCommand = cmdPrefix + "<masked>"
+
+// keywordPassword matches the password of a keyword/value connection string
+var keywordPassword = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// ResolveSecret returns value, or the secret it refers to when it starts with
+// file: or cmd:. Surrounding whitespace, such as a trailing newline, is trimmed
+// from the secret. Errors never include the secret itself.
+func ResolveSecret(value string) (string, error) {
+	if path, ok := strings.CutPrefix(value, filePrefix); ok {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %w", err)
+		}
+		return strings.TrimSpace(string(content)), nil
+	}
+
+	if command, ok := strings.CutPrefix(value, cmdPrefix); ok {
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.Command("cmd", "/C", command)
+		} else {
+			cmd = exec.Command("sh", "-c", command)
+		}
+
+		// Let password managers prompt on the terminal
+		var output bytes.Buffer
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = &output
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("failed to run secret command %q: %w", command, err)
+		}
+		return strings.TrimSpace(output.String()), nil
+	}
+
+	return value, nil
+}
+
+// ResolveSecretForDisplay resolves value like ResolveSecret, except that a
+// cmd: secret is not run and resolves to MaskedCommand
+func ResolveSecretForDisplay(value string) (string, error) {
+	if strings.HasPrefix(value, cmdPrefix) {
+		return MaskedCommand, nil
+	}
+	return ResolveSecret(value)
+}
+
+// resolveSecrets resolves every file: and cmd: value in v, whatever its source.
+// Without runCommands, cmd: values are masked instead of run.
+// Environments are skipped: the selected one is already merged, and the secrets
+// of the others are not needed.
+func resolveSecrets(v *viper.Viper, runCommands bool) error {
+	resolve := ResolveSecret
+	if !runCommands {
+		resolve = ResolveSecretForDisplay
+	}
+
+	for _, key := range v.AllKeys() {
+		if strings.HasPrefix(key, environmentsKey+".") {
+			continue
+		}
+		switch value := v.Get(key).(type) {
+		case string:
+			resolved, err := resolve(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			if resolved != value {
+				v.Set(key, resolved)
+			}
+		case []any:
+			changed := false
+			for i, item := range value {
+				if s, ok := item.(string); ok {
+					resolved, err := resolve(s)
+					if err != nil {
+						return fmt.Errorf("%s: %w", key, err)
+					}
+					changed = changed || resolved != s
+					value[i] = resolved
+				}
+			}
+			if changed {
+				v.Set(key, value)
+			}
+		}
+	}
+	return nil
+}
+
+// MaskPassword returns a connection URL or keyword/value connection string
+// with its password replaced, for display
+func MaskPassword(connection string) string {
+	if !strings.Contains(connection, "://") {
+		return keywordPassword.ReplaceAllString(connection, "${1}"+redacted)
+	}
+
+	u, err := url.Parse(connection)
+	if err != nil {
+		return redacted
+	}
+	query := u.Query()
+	if query.Has("password") {
+		query.Set("password", redacted)
+		u.RawQuery = query.Encode()
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Set is an independent set of migrations sharing the database with other sets.
+// Each set has its own migration directory and tracking table.
+type Set struct {
+	// MigrationDir defaults to <migrationDir>/<set name>
+	MigrationDir string `mapstructure:"migrationDir"`
+
+	// MigrationsSchema defaults to the top-level migrationsSchema,
+	// MigrationsTable to <set name>_migrations
+	MigrationsSchema string `mapstructure:"migrationsSchema"`
+	MigrationsTable  string `mapstructure:"migrationsTable"`
+
+	// DependsOn lists sets whose migrations must be applied before this set's
+	DependsOn []string `mapstructure:"dependsOn"`
+}
+
+// SetNames returns the names of all configured sets, sorted
+func (c *Config) SetNames() []string {
+	names := make([]string, 0, len(c.Sets))
+	for name := range c.Sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupSet returns the set with the given name, compared case-insensitively,
+// and its name as configured
+func (c *Config) lookupSet(name string) (Set, string, bool) {
+	if set, ok := c.Sets[name]; ok {
+		return set, name, true
+	}
+	for configured, set := range c.Sets {
+		if strings.EqualFold(configured, name) {
+			return set, configured, true
+		}
+	}
+	return Set{}, "", false
+}
+
+// ForSet returns the configuration for the named set, with the set's migration
+// directory and tracking table in place of the top-level ones. The set's
+// migration directory is created if needed. An empty name selects the
+// top-level configuration, which is only allowed when no sets are configured.
+// Set names are case-insensitive.
+func (c *Config) ForSet(name string) (*Config, error) {
+	setConfig, err := c.ForSetReadOnly(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if setConfig != c {
+		if err := ensureMigrationDir(setConfig.MigrationDir); err != nil {
+			return nil, err
+		}
+	}
+
+	return setConfig, nil
+}
+
+// ForSetReadOnly returns the configuration for the named set like ForSet, but
+// leaves the set's migration directory alone. It is meant for reading another
+// set's history.
+func (c *Config) ForSetReadOnly(name string) (*Config, error) {
+	if name == "" {
+		if len(c.Sets) > 0 {
+			return nil, fmt.Errorf("several migration sets are configured; choose one with --set (%s)", strings.Join(c.SetNames(), ", "))
+		}
+		return c, nil
+	}
+
+	set, configured, ok := c.lookupSet(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown migration set %q", name)
+	}
+	name = configured
+
+	setConfig := *c
+	setConfig.MigrationDir = set.MigrationDir
+	if setConfig.MigrationDir == "" {
+		setConfig.MigrationDir = filepath.Join(c.MigrationDir, name)
+	}
+	if set.MigrationsSchema != "" {
+		setConfig.MigrationsSchema = set.MigrationsSchema
+	}
+	setConfig.MigrationsTable = set.MigrationsTable
+	if setConfig.MigrationsTable == "" {
+		setConfig.MigrationsTable = name + "_migrations"
+	}
+
+	return &setConfig, nil
+}
+
+// SetOrder returns all set names ordered so that every set comes after the sets
+// it depends on. Sets without a dependency between them are ordered by name, and
+// dependencies are matched case-insensitively.
+func (c *Config) SetOrder() ([]string, error) {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+
+	state := make(map[string]int, len(c.Sets))
+	var order []string
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("migration sets depend on each other in a cycle: %s", strings.Join(append(path, name), " -> "))
+		}
+
+		state[name] = visiting
+		dependsOn := append([]string(nil), c.Sets[name].DependsOn...)
+		sort.Strings(dependsOn)
+		for _, dependency := range dependsOn {
+			_, configured, ok := c.lookupSet(dependency)
+			if !ok {
+				return fmt.Errorf("migration set %q depends on unknown set %q", name, dependency)
+			}
+			if err := visit(configured, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		order = append(order, name)
+		return nil
+	}
+
+	for _, name := range c.SetNames() {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestForSet(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{
+		MigrationDir:     dir,
+		MigrationsSchema: "rf_migrate",
+		MigrationsTable:  "migrations",
+		Sets: map[string]Set{
+			"accounts": {},
+			"billing":  {MigrationDir: filepath.Join(dir, "billing-custom"), MigrationsTable: "billing_history"},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		set       string
+		wantDir   string
+		wantTable string
+		wantErr   string
+	}{
+		{name: "defaults", set: "accounts", wantDir: filepath.Join(dir, "accounts"), wantTable: "accounts_migrations"},
+		{name: "mixed case", set: "Accounts", wantDir: filepath.Join(dir, "accounts"), wantTable: "accounts_migrations"},
+		{name: "configured", set: "BILLING", wantDir: filepath.Join(dir, "billing-custom"), wantTable: "billing_history"},
+		{name: "unknown", set: "orders", wantErr: `unknown migration set "orders"`},
+		{name: "none selected", set: "", wantErr: "choose one with --set"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig, err := cfg.ForSet(tt.set)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ForSet(%q) error = %v, want %q", tt.set, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ForSet(%q) error = %v", tt.set, err)
+			}
+			if setConfig.MigrationDir != tt.wantDir || setConfig.MigrationsTable != tt.wantTable {
+				t.Errorf("ForSet(%q) = %s, %s, want %s, %s", tt.set, setConfig.MigrationDir, setConfig.MigrationsTable, tt.wantDir, tt.wantTable)
+			}
+			if _, err := os.Stat(filepath.Join(tt.wantDir, "current.sql")); err != nil {
+				t.Errorf("current.sql not created: %v", err)
+			}
+		})
+	}
+}
+
+func TestSetOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		sets    map[string]Set
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "independent sets by name",
+			sets: map[string]Set{"orders": {}, "billing": {}, "accounts": {}},
+			want: []string{"accounts", "billing", "orders"},
+		},
+		{
+			name: "dependencies first",
+			sets: map[string]Set{
+				"accounts": {DependsOn: []string{"users"}},
+				"billing":  {DependsOn: []string{"accounts", "orders"}},
+				"orders":   {},
+				"users":    {},
+			},
+			want: []string{"users", "accounts", "orders", "billing"},
+		},
+		{
+			name: "mixed case dependency",
+			sets: map[string]Set{"accounts": {}, "billing": {DependsOn: []string{"Accounts"}}},
+			want: []string{"accounts", "billing"},
+		},
+		{
+			name:    "unknown dependency",
+			sets:    map[string]Set{"billing": {DependsOn: []string{"accounts"}}},
+			wantErr: `migration set "billing" depends on unknown set "accounts"`,
+		},
+		{
+			name: "cycle",
+			sets: map[string]Set{
+				"a": {DependsOn: []string{"b"}},
+				"b": {DependsOn: []string{"c"}},
+				"c": {DependsOn: []string{"a"}},
+			},
+			wantErr: "cycle: a -> b -> c -> a",
+		},
+		{
+			name:    "self dependency",
+			sets:    map[string]Set{"a": {DependsOn: []string{"a"}}},
+			wantErr: "cycle: a -> a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := (&Config{Sets: tt.sets}).SetOrder()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("SetOrder() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SetOrder() error = %v", err)
+			}
+			if !reflect.DeepEqual(order, tt.want) {
+				t.Errorf("SetOrder() = %v, want %v", order, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigSets(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want []string
+	}{
+		{
+			name: "empty set entry",
+			yaml: "sets:\n  accounts: {}\n  billing:\n    dependsOn: [accounts]\n",
+			want: []string{"accounts", "billing"},
+		},
+		{
+			name: "mixed case names",
+			yaml: "sets:\n  Accounts: {}\n  Billing:\n    dependsOn: [ACCOUNTS]\n",
+			want: []string{"accounts", "billing"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "rfmigrate.yaml")
+			content := "migrationDir: " + filepath.Join(dir, "db") + "\n" + tt.yaml
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := LoadConfig(path)
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+			order, err := cfg.SetOrder()
+			if err != nil {
+				t.Fatalf("SetOrder() error = %v", err)
+			}
+			if !reflect.DeepEqual(order, tt.want) {
+				t.Errorf("SetOrder() = %v, want %v", order, tt.want)
+			}
+			if _, err := cfg.ForSet("Accounts"); err != nil {
+				t.Errorf("ForSet(Accounts) error = %v", err)
+			}
+		})
+	}
+}
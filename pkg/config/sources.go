@@ -0,0 +1,49 @@
+package config
+
+import (
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// settingSources describes where each setting in v came from. Flags are applied
+// after loading, so their callers record them.
+func settingSources(v *viper.Viper, environment string, environmentKeys []string) map[string]string {
+	fromEnvironment := make(map[string]bool, len(environmentKeys))
+	for _, key := range environmentKeys {
+		fromEnvironment[key] = true
+	}
+
+	sources := make(map[string]string)
+	for _, key := range v.AllKeys() {
+		if strings.HasPrefix(key, environmentsKey+".") {
+			continue
+		}
+
+		switch {
+		case envVarFor(key) != "":
+			sources[key] = "env " + envVarFor(key)
+		case fromEnvironment[key]:
+			sources[key] = "environment " + environment + " in " + v.ConfigFileUsed()
+		case v.InConfig(key):
+			sources[key] = "file " + v.ConfigFileUsed()
+		case v.IsSet(key):
+			sources[key] = "default"
+		}
+	}
+	return sources
+}
+
+// envVarFor returns the environment variable that sets the setting, or "" if none
+// is set. Like viper, it ignores empty variables.
+func envVarFor(key string) string {
+	for _, binding := range envBindings {
+		if strings.ToLower(binding.key) == key {
+			if os.Getenv(binding.env) != "" {
+				return binding.env
+			}
+		}
+	}
+	return ""
+}
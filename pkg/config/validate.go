@@ -0,0 +1,87 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// connectionSchemes are the URL schemes rf-migrate can connect with
+var connectionSchemes = []string{"postgres", "postgresql", "pgx", "sqlite", "mysql"}
+
+// keywordConnection matches a keyword/value connection string such as
+// "host=db.internal dbname=app password='a b'"
+var keywordConnection = regexp.MustCompile(`^\s*(\w+\s*=\s*('(?:[^'\\]|\\.)*'|[^\s']*)\s*)*$`)
+
+// Validate checks the settings that can be checked without connecting: the
+// database driver, connection URLs, tracking table, sets and, when no sets are
+// configured, the migration directory, which is created if missing
+func (c *Config) Validate() error {
+	if c.DatabaseDriver != "" && c.DatabaseDriver != "pq" && c.DatabaseDriver != "pgx" {
+		return fmt.Errorf("invalid databaseDriver %q: expected pq or pgx", c.DatabaseDriver)
+	}
+
+	if err := validateConnection(c.DatabaseURL); err != nil {
+		return fmt.Errorf("invalid databaseUrl: %w", err)
+	}
+	for i, url := range c.DatabaseURLs {
+		if err := validateConnection(url); err != nil {
+			return fmt.Errorf("invalid databaseUrls entry %d: %w", i+1, err)
+		}
+	}
+
+	if c.MigrationsSchema == "" || c.MigrationsTable == "" {
+		return fmt.Errorf("migrationsSchema and migrationsTable must not be empty")
+	}
+
+	// Sets must be orderable before any of them is used
+	if _, err := c.SetOrder(); err != nil {
+		return err
+	}
+
+	// Ensure migration directory exists; sets create their own when selected
+	if len(c.Sets) == 0 {
+		if err := ensureMigrationDir(c.MigrationDir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateConnection checks that a connection string is a URL with a known
+// scheme or a keyword/value connection string. Errors leave out the connection
+// string, which may hold a password.
+func validateConnection(connection string) error {
+	if connection == "" || connection == MaskedCommand {
+		return nil // A masked secret is only checked once its command runs
+	}
+
+	if !strings.Contains(connection, "://") {
+		if !strings.Contains(connection, "=") || !keywordConnection.MatchString(connection) {
+			return errors.New("neither a URL such as postgres://host/dbname nor a keyword/value string such as \"host=... dbname=...\"")
+		}
+		return nil
+	}
+
+	u, err := url.Parse(connection)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("malformed URL: %w", err)
+	}
+
+	for _, scheme := range connectionSchemes {
+		if u.Scheme == scheme {
+			if scheme == "sqlite" && u.Host+u.Path == "" {
+				return errors.New("sqlite:// URL names no database file")
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported URL scheme %q: expected one of %s", u.Scheme, strings.Join(connectionSchemes, "://, ")+"://")
+}
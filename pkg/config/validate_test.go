@@ -0,0 +1,82 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateConnection(t *testing.T) {
+	tests := []struct {
+		connection string
+		wantErr    string
+	}{
+		{connection: ""},
+		{connection: "postgres://app:pw@db:5432/app?sslmode=disable"},
+		{connection: "postgresql://db/app"},
+		{connection: "pgx://db/app"},
+		{connection: "mysql://root@localhost/app"},
+		{connection: "sqlite://dev.db"},
+		{connection: "host=db dbname=app"},
+		{connection: "host=db password='a b' dbname=app"},
+		{connection: "sqlite://", wantErr: "names no database file"},
+		{connection: "http://db/app", wantErr: `unsupported URL scheme "http"`},
+		{connection: "postgres://app:pw@db:badport/app", wantErr: "malformed URL"},
+		{connection: "localhost:5432/app", wantErr: "neither a URL"},
+		{connection: "host=db password='unterminated", wantErr: "neither a URL"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.connection, func(t *testing.T) {
+			err := validateConnection(tt.connection)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validateConnection(%q) error = %v", tt.connection, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validateConnection(%q) error = %v, want %q", tt.connection, err, tt.wantErr)
+			}
+			if strings.Contains(err.Error(), "pw") {
+				t.Errorf("error %q leaks the password", err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() Config {
+		return Config{MigrationDir: t.TempDir(), DatabaseDriver: "pq", MigrationsSchema: "rf_migrate", MigrationsTable: "migrations"}
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{name: "valid", modify: func(c *Config) {}},
+		{name: "unknown driver", modify: func(c *Config) { c.DatabaseDriver = "odbc" }, wantErr: `invalid databaseDriver "odbc"`},
+		{name: "bad URL", modify: func(c *Config) { c.DatabaseURL = "http://db/app" }, wantErr: "invalid databaseUrl"},
+		{name: "bad extra URL", modify: func(c *Config) { c.DatabaseURLs = []string{"sqlite://a.db", "nope"} }, wantErr: "invalid databaseUrls entry 2"},
+		{name: "empty table", modify: func(c *Config) { c.MigrationsTable = "" }, wantErr: "must not be empty"},
+		{name: "set cycle", modify: func(c *Config) { c.Sets = map[string]Set{"a": {DependsOn: []string{"a"}}} }, wantErr: "cycle"},
+		{name: "migration dir is a file", modify: func(c *Config) { c.MigrationDir = "/dev/null" }, wantErr: "failed to create migration directory"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.modify(&cfg)
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// statement is a statement run against a recordingConnector
+type statement struct {
+	query string
+	inTx  bool
+}
+
+// recordingConnector opens connections that log their statements instead of
+// running them. Queries return a single empty string; statements containing
+// "fail" return an error.
+type recordingConnector struct {
+	mu         sync.Mutex
+	statements []statement
+}
+
+func (c *recordingConnector) Connect(context.Context) (driver.Conn, error) {
+	return &recordingConn{connector: c}, nil
+}
+func (c *recordingConnector) Driver() driver.Driver { return nil }
+
+func (c *recordingConnector) log(query string, inTx bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.statements = append(c.statements, statement{query: query, inTx: inTx})
+	if strings.Contains(query, "fail") {
+		return errors.New("statement failed")
+	}
+	return nil
+}
+
+type recordingConn struct {
+	connector *recordingConnector
+	inTx      bool
+}
+
+func (c *recordingConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+func (c *recordingConn) Close() error { return nil }
+func (c *recordingConn) Begin() (driver.Tx, error) {
+	c.inTx = true
+	return c, nil
+}
+func (c *recordingConn) Commit() error {
+	c.inTx = false
+	return nil
+}
+func (c *recordingConn) Rollback() error {
+	c.inTx = false
+	return nil
+}
+
+func (c *recordingConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	if err := c.connector.log(query, c.inTx); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *recordingConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	if err := c.connector.log(query, c.inTx); err != nil {
+		return nil, err
+	}
+	return &singleRow{}, nil
+}
+
+// singleRow is a result with one row holding an empty string
+type singleRow struct{ done bool }
+
+func (r *singleRow) Columns() []string { return []string{"value"} }
+func (r *singleRow) Close() error      { return nil }
+func (r *singleRow) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = ""
+	return nil
+}
+
+func TestApplyCockroachMigration(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantErr    bool
+		wantRecord bool
+	}{
+		{name: "applied", query: "create table accounts (id int primary key); create index on accounts (id);", wantRecord: true},
+		{name: "failing migration", query: "alter table fail add column name text;", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			connector := &recordingConnector{}
+			pool := sql.OpenDB(connector)
+			defer pool.Close()
+			pdb := &PostgresDB{db: pool, tracking: DefaultTrackingTable, ctx: context.Background(), dialect: dialectCockroach}
+
+			_, err := pdb.ApplyMigration(tt.query, Migration{Hash: "abc", FileName: "20240101120000_accounts.sql"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ApplyMigration() error = %v, want error: %v", err, tt.wantErr)
+			}
+
+			migration, record, renew := -1, -1, -1
+			for i, s := range connector.statements {
+				switch {
+				case s.query == tt.query:
+					migration = i
+					if s.inTx {
+						t.Errorf("migration ran in a transaction")
+					}
+				case strings.Contains(s.query, "insert into "+pdb.table("")):
+					record = i
+					if !s.inTx {
+						t.Errorf("record was inserted outside a transaction")
+					}
+				case strings.Contains(s.query, "update "+pdb.table("_lease")) && s.inTx:
+					renew = i
+				}
+			}
+
+			if migration < 0 {
+				t.Fatalf("migration did not run; statements: %v", connector.statements)
+			}
+			if !tt.wantRecord {
+				if record >= 0 {
+					t.Errorf("failed migration was recorded")
+				}
+				return
+			}
+			if record < migration {
+				t.Errorf("record inserted at %d, before the migration ran at %d", record, migration)
+			}
+			if renew < record {
+				t.Errorf("lease renewed at %d, want it renewed in the record transaction after %d", renew, record)
+			}
+		})
+	}
+}
+
+func TestApplyWithoutTransaction(t *testing.T) {
+	connector := &recordingConnector{}
+	pool := sql.OpenDB(connector)
+	defer pool.Close()
+	pdb := &PostgresDB{db: pool, tracking: DefaultTrackingTable, ctx: context.Background()}
+
+	query := "create index concurrently orders_user_id on orders (user_id);"
+	_, err := pdb.ApplyMigration(query, Migration{Hash: "abc", FileName: "20240101120000_index.sql", NoTransaction: true})
+	if err != nil {
+		t.Fatalf("ApplyMigration() error = %v", err)
+	}
+
+	var got []string
+	for _, s := range connector.statements {
+		var step string
+		switch {
+		case strings.Contains(s.query, "pg_advisory_lock"):
+			step = "lock"
+		case strings.Contains(s.query, "pg_advisory_unlock"):
+			step = "unlock"
+		case s.query == query:
+			step = "migration"
+		case strings.Contains(s.query, "insert into "+pdb.table("")):
+			step = "record"
+		default:
+			continue
+		}
+		if s.inTx {
+			step += " in transaction"
+		}
+		got = append(got, step)
+	}
+
+	want := []string{"lock", "migration", "record in transaction", "unlock"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("statements = %v, want %v", got, want)
+	}
+}
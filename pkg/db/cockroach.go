@@ -0,0 +1,314 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Lease timing on CockroachDB. A lease is renewed well before it expires, so it
+// only lapses when its holder has died without releasing it.
+const (
+	leaseDuration     = time.Minute
+	leasePollInterval = time.Second
+)
+
+// cockroachUpgrades brings the bookkeeping tables up to date on CockroachDB, like
+// schemaUpgrades does on PostgreSQL; it uses the same placeholders. Each step
+// runs in its own transaction, so a step holds at most one schema change.
+// Steps must be idempotent: tracking tables created before the meta table
+// existed start at version 0, so step 2 leaves them as they are and the later
+// steps add what they lack.
+var cockroachUpgrades = []string{
+	// 1: apply order sequence
+	`create sequence if not exists {sequence};`,
+
+	// 2: migrations table with audit metadata and apply order
+	`create table if not exists {migrations} (
+		hash text primary key,
+		previous_hash text,
+		file_name text not null,
+		date timestamp not null default now(),
+		applied_by text,
+		host text,
+		duration_ms bigint,
+		tool_version text,
+		git_commit text,
+		seq bigint not null default nextval({sequence_name}),
+		constraint {sequence_index} unique (seq)
+	);`,
+
+	// 3-7: audit metadata
+	`alter table {migrations} add column if not exists applied_by text;`,
+	`alter table {migrations} add column if not exists host text;`,
+	`alter table {migrations} add column if not exists duration_ms bigint;`,
+	`alter table {migrations} add column if not exists tool_version text;`,
+	`alter table {migrations} add column if not exists git_commit text;`,
+
+	// 8: apply order column
+	`alter table {migrations} add column if not exists seq bigint;`,
+
+	// 9: existing rows are numbered by date, then file name
+	`update {migrations} m
+	set seq = o.seq
+	from (
+		select hash, coalesce((select max(seq) from {migrations}), 0)
+			+ row_number() over (order by date, file_name) as seq
+		from {migrations}
+		where seq is null
+	) o
+	where m.hash = o.hash;
+	select setval({sequence_name}, coalesce((select max(seq) from {migrations}), 0) + 1, false);`,
+
+	// 10-12: new rows are numbered by the sequence
+	`alter table {migrations} alter column seq set default nextval({sequence_name});`,
+	`alter table {migrations} alter column seq set not null;`,
+	`create unique index if not exists {sequence_index} on {migrations} (seq);`,
+}
+
+// ensureCockroachTables is EnsureMigrationsTable for CockroachDB. Schema changes
+// there cannot be mixed freely with other statements in one transaction, so the
+// schema, meta and lease tables are created first, and then each upgrade step runs
+// in its own transaction that also records its version, under the schema lease.
+func (pdb *PostgresDB) ensureCockroachTables() error {
+	// Create schema if missing; only checking first lets existing schemas be
+	// used by roles that may not create schemas
+	var schemaExists bool
+	if err := pdb.db.QueryRowContext(pdb.ctx, `select exists (select 1 from pg_namespace where nspname = $1);`, pdb.tracking.Schema).Scan(&schemaExists); err != nil {
+		return fmt.Errorf("failed to look up schema: %w", err)
+	}
+	if !schemaExists {
+		if _, err := pdb.db.ExecContext(pdb.ctx, `create schema if not exists `+pq.QuoteIdentifier(pdb.tracking.Schema)+`;`); err != nil {
+			return fmt.Errorf("failed to create schema: %w", err)
+		}
+	}
+
+	// Create meta and lease tables if not exists
+	if _, err := pdb.db.ExecContext(pdb.ctx, pdb.metaTableQuery()); err != nil {
+		return fmt.Errorf("failed to create meta table: %w", err)
+	}
+
+	leaseQuery := fmt.Sprintf(`
+	create table if not exists %s (
+		name text primary key,
+		holder text not null,
+		expires_at timestamptz not null
+	);`, pdb.table("_lease"))
+
+	if _, err := pdb.db.ExecContext(pdb.ctx, leaseQuery); err != nil {
+		return fmt.Errorf("failed to create lease table: %w", err)
+	}
+
+	l, err := pdb.acquireLease(schemaLock.lease)
+	if err != nil {
+		return err
+	}
+	defer l.release()
+
+	// Run the missing upgrade steps one transaction at a time
+	expand := pdb.upgradeReplacer()
+	for {
+		done := false
+		err := pdb.inTx(func(tx *sql.Tx) error {
+			version, err := pdb.schemaVersion(tx)
+			if err != nil {
+				return err
+			}
+			if version > len(cockroachUpgrades) {
+				return fmt.Errorf("bookkeeping schema version %d of %s is newer than this rf-migrate supports (%d); please upgrade rf-migrate", version, pdb.tracking, len(cockroachUpgrades))
+			}
+			if version == len(cockroachUpgrades) {
+				done = true
+				return nil
+			}
+
+			if _, err := tx.ExecContext(pdb.ctx, expand.Replace(cockroachUpgrades[version])); err != nil {
+				return fmt.Errorf("failed to upgrade bookkeeping schema to version %d: %w", version+1, err)
+			}
+			if err := pdb.setSchemaVersion(tx, version+1); err != nil {
+				return err
+			}
+			return l.renew(tx)
+		})
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// applyCockroachMigration runs a migration while holding the apply lease, then
+// records it in a transaction of its own, with or without NoTransaction. CockroachDB runs schema changes
+// asynchronously and rejects many of them next to writes in one transaction, so
+// the migration SQL runs as a statement batch, which CockroachDB applies as one
+// implicit transaction, outside the record transaction. A migration that
+// succeeds but cannot be recorded is reported as such, as its changes remain.
+func (pdb *PostgresDB) applyCockroachMigration(query string, m Migration) (Migration, error) {
+	held, err := pdb.acquireLease(applyLock.lease)
+	if err != nil {
+		return Migration{}, err
+	}
+	defer held.release()
+
+	if err := runMigration(pdb.ctx, pdb.db, query, &m); err != nil {
+		return Migration{}, err
+	}
+
+	err = pdb.inTx(func(tx *sql.Tx) error {
+		if err := pdb.recordMigration(tx, &m); err != nil {
+			return err
+		}
+		return held.renew(tx)
+	})
+	if err != nil {
+		return Migration{}, fmt.Errorf("migration %s was applied but could not be recorded: %w", m.FileName, err)
+	}
+
+	return m, nil
+}
+
+// lease is a lease row held by this process
+type lease struct {
+	pdb      *PostgresDB
+	name     string
+	holder   string
+	duration string // Lease duration as an interval literal
+
+	stop    chan struct{}
+	stopped chan struct{}
+
+	mu       sync.Mutex
+	renewErr error // Last failed background renewal, reported if the lease is lost
+}
+
+// execer runs statements; *sql.Tx and SQLHandle implement it
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// acquireLease waits until it holds the named lease row and keeps renewing it
+// in the background until it is released
+func (pdb *PostgresDB) acquireLease(name string) (*lease, error) {
+	host, _ := os.Hostname()
+	l := &lease{
+		pdb:      pdb,
+		name:     name,
+		holder:   fmt.Sprintf("%s:%d:%d", host, os.Getpid(), time.Now().UnixNano()),
+		duration: fmt.Sprintf("%d seconds", int(leaseDuration.Seconds())),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+
+	// Insert the lease, or take it over once it has expired
+	acquireQuery := fmt.Sprintf(`
+	insert into %s as l (name, holder, expires_at) values ($1, $2, now() + $3::interval)
+	on conflict (name) do update set holder = excluded.holder, expires_at = excluded.expires_at
+	where l.expires_at < now()
+	returning holder;`, pdb.table("_lease"))
+
+	ticker := time.NewTicker(leasePollInterval)
+	defer ticker.Stop()
+	for {
+		var acquired string
+		err := pdb.db.QueryRowContext(pdb.ctx, acquireQuery, name, l.holder, l.duration).Scan(&acquired)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to take %s lease: %w", name, err)
+		}
+
+		select {
+		case <-pdb.ctx.Done():
+			return nil, fmt.Errorf("failed to take %s lease: %w", name, pdb.ctx.Err())
+		case <-ticker.C:
+		}
+	}
+
+	go l.keepRenewed()
+	return l, nil
+}
+
+// keepRenewed renews the lease until it is released or lost. Failures are kept
+// for renew to report; a lost lease is not renewed again.
+func (l *lease) keepRenewed() {
+	defer close(l.stopped)
+
+	ticker := time.NewTicker(leaseDuration / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if err := l.extend(l.pdb.db); err != nil {
+				l.mu.Lock()
+				l.renewErr = err
+				l.mu.Unlock()
+				if errors.Is(err, errLeaseLost) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// errLeaseLost reports that a lease expired and may have been taken over
+var errLeaseLost = errors.New("lease expired")
+
+// extend renews the lease with db, as long as it is still held
+func (l *lease) extend(db execer) error {
+	renewQuery := fmt.Sprintf(`
+	update %s set expires_at = now() + $3::interval
+	where name = $1 and holder = $2 and expires_at > now();`, l.pdb.table("_lease"))
+
+	result, err := db.ExecContext(l.pdb.ctx, renewQuery, l.name, l.holder, l.duration)
+	if err != nil {
+		return fmt.Errorf("failed to renew %s lease: %w", l.name, err)
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to renew %s lease: %w", l.name, err)
+	}
+	if count != 1 {
+		return errLeaseLost
+	}
+	return nil
+}
+
+// renew renews the lease in tx, so the transaction only commits while the lease
+// is held: a process taking over the expired lease conflicts with it
+func (l *lease) renew(tx *sql.Tx) error {
+	err := l.extend(tx)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, errLeaseLost) {
+		return err
+	}
+
+	l.mu.Lock()
+	renewErr := l.renewErr
+	l.mu.Unlock()
+	if renewErr != nil && !errors.Is(renewErr, errLeaseLost) {
+		return fmt.Errorf("lost the %s lease of %s after renewing it failed: %w", l.name, l.pdb.tracking, renewErr)
+	}
+	return fmt.Errorf("lost the %s lease of %s: %w", l.name, l.pdb.tracking, err)
+}
+
+// release stops renewing the lease and deletes it
+func (l *lease) release() {
+	close(l.stop)
+	<-l.stopped
+
+	releaseQuery := fmt.Sprintf(`delete from %s where name = $1 and holder = $2;`, l.pdb.table("_lease"))
+	l.pdb.db.ExecContext(context.Background(), releaseQuery, l.name, l.holder) //nolint:errcheck
+}
@@ -1,11 +1,15 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"io"
+	"os"
+	"os/user"
 	"time"
 
-	_ "github.com/lib/pq" // PostgreSQL driver
+	"github.com/lib/pq" // PostgreSQL driver
 )
 
 // DB represents a database connection
@@ -19,50 +23,101 @@ type DB interface {
 	// Close closes the database connection
 	Close() error
 
-	// EnsureMigrationsTable ensures that the migrations table exists
+	// EnsureMigrationsTable ensures that the migrations table exists and
+	// upgrades the bookkeeping tables to the current layout
 	EnsureMigrationsTable() error
 
-	// ApplyMigration applies a migration and records it
-	ApplyMigration(fileName string, hash string, previousHash string) error
+	// ApplyMigration runs a migration and records it, in a single transaction
+	// unless the migration sets NoTransaction or the database is CockroachDB. PreviousHash, AppliedBy, Host, Duration and RowsAffected are filled in
+	// when recording, and the completed record is returned.
+	ApplyMigration(query string, migration Migration) (Migration, error)
 
-	// GetAppliedMigrations returns all applied migrations
+	// GetAppliedMigrations returns all applied migrations in the order they were applied
 	GetAppliedMigrations() ([]Migration, error)
 
-	// RemoveLastMigration removes the last migration from the migrations table
-	RemoveLastMigration() (Migration, error)
+	// RemoveMigrations removes the given migrations from the migrations table in a single transaction
+	RemoveMigrations(hashes []string) error
+
+	// DescribeSchema returns a sorted, line-per-object description of the user schema
+	DescribeSchema() ([]string, error)
+}
+
+// ContextDB is a DB whose lookup of applied migrations can be bounded by a
+// context other than the one it was opened with. Every DB in this package
+// implements it.
+type ContextDB interface {
+	DB
+
+	// GetAppliedMigrationsContext is GetAppliedMigrations, running the query with ctx
+	GetAppliedMigrationsContext(ctx context.Context) ([]Migration, error)
 }
 
 // Migration represents a migration record
 type Migration struct {
+	Seq          int64 // Position in the order migrations were applied
 	Hash         string
 	PreviousHash string
 	FileName     string
 	Date         time.Time
+
+	// Audit metadata; empty for migrations recorded by older versions
+	AppliedBy   string        // Operating system user that applied the migration
+	Host        string        // Host the migration was applied from
+	Duration    time.Duration // Time spent running the migration SQL
+	ToolVersion string        // rf-migrate version that applied the migration
+	GitCommit   string        // Git commit of the migrations directory, if any
+
+	// RowsAffected is reported by the last statement of the migration when it is
+	// applied; it is not recorded
+	RowsAffected int64
+
+	// NoTransaction runs the migration outside the transaction that records it,
+	// for statements such as create index concurrently; it is not recorded
+	NoTransaction bool
+}
+
+// TrackingTable names the table that records applied migrations.
+// Its bookkeeping companions live in the same schema, named after it:
+// <name>_meta holds the layout version and <name>_seq the apply order;
+// on CockroachDB <name>_lease holds the locks.
+type TrackingTable struct {
+	Schema string
+	Name   string
 }
 
-// PostgresDB is a PostgreSQL implementation of DB
+// DefaultTrackingTable is the tracking table used unless configured otherwise
+var DefaultTrackingTable = TrackingTable{Schema: "rf_migrate", Name: "migrations"}
+
+// String returns the schema-qualified table name
+func (t TrackingTable) String() string {
+	return t.Schema + "." + t.Name
+}
+
+// PostgresDB is a PostgreSQL implementation of DB on top of database/sql,
+// driven by lib/pq or pgx
 type PostgresDB struct {
-	db *sql.DB
+	db       SQLHandle
+	closer   io.Closer // Closes the pool opened by Open; nil for handles owned by the caller
+	tracking TrackingTable
+	ctx      context.Context // Cancels running statements, see WithContext
+	dialect  dialect         // Detected when connecting
 }
 
-// NewPostgresDB creates a new PostgreSQL database connection
+// NewPostgresDB creates a new PostgreSQL database connection that records
+// migrations in DefaultTrackingTable. Use Open for another tracking table or driver.
 func NewPostgresDB(url string) (DB, error) {
-	db, err := sql.Open("postgres", url)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to database: %w", err)
-	}
-
-	// Test connection
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
-	}
+	return Open(url, DefaultTrackingTable)
+}
 
-	return &PostgresDB{db: db}, nil
+// table returns the quoted, schema-qualified name of a bookkeeping table.
+// The suffix is appended to the tracking table name, so "" is the tracking table itself.
+func (pdb *PostgresDB) table(suffix string) string {
+	return pq.QuoteIdentifier(pdb.tracking.Schema) + "." + pq.QuoteIdentifier(pdb.tracking.Name+suffix)
 }
 
 // Execute runs a SQL query with no rows returned
 func (pdb *PostgresDB) Execute(query string) error {
-	_, err := pdb.db.Exec(query)
+	_, err := pdb.db.ExecContext(pdb.ctx, query)
 	if err != nil {
 		return fmt.Errorf("failed to execute query: %w", err)
 	}
@@ -71,7 +126,7 @@ func (pdb *PostgresDB) Execute(query string) error {
 
 // Query runs a SQL query with rows returned
 func (pdb *PostgresDB) Query(query string) (*sql.Rows, error) {
-	rows, err := pdb.db.Query(query)
+	rows, err := pdb.db.QueryContext(pdb.ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query database: %w", err)
 	}
@@ -80,115 +135,189 @@ func (pdb *PostgresDB) Query(query string) (*sql.Rows, error) {
 
 // Close closes the database connection
 func (pdb *PostgresDB) Close() error {
-	return pdb.db.Close()
+	if pdb.closer == nil {
+		return nil
+	}
+	return pdb.closer.Close()
 }
 
-// EnsureMigrationsTable ensures that the migrations table exists
-func (pdb *PostgresDB) EnsureMigrationsTable() error {
-	// Create schema if not exists
-	schemaQuery := `create schema if not exists rf_migrate;`
-	if _, err := pdb.db.Exec(schemaQuery); err != nil {
-		return fmt.Errorf("failed to create schema: %w", err)
-	}
+// ApplyMigration runs a migration and records it in a single transaction,
+// so a failing migration leaves neither schema changes nor a record behind.
+// The previous hash is that of the migration with the highest sequence number.
+// CockroachDB records the migration in a transaction of its own, see applyCockroachMigration,
+// and so does PostgreSQL for NoTransaction migrations, see applyWithoutTransaction.
+func (pdb *PostgresDB) ApplyMigration(query string, m Migration) (Migration, error) {
+	fillAuditFields(&m)
 
-	// Create table if not exists
-	query := `
-	create table if not exists rf_migrate.migrations (
-		hash text primary key,
-		previous_hash text,
-		file_name text not null,
-		date timestamp not null default now()
-	);`
+	if pdb.dialect == dialectCockroach {
+		return pdb.applyCockroachMigration(query, m)
+	}
+	if m.NoTransaction {
+		return pdb.applyWithoutTransaction(query, m)
+	}
 
-	if _, err := pdb.db.Exec(query); err != nil {
-		return fmt.Errorf("failed to create migrations table: %w", err)
+	// Serialize concurrent applies so each links to the one before it
+	err := pdb.inLockedTx(applyLock, func(tx *sql.Tx) error {
+		if err := runMigration(pdb.ctx, tx, query, &m); err != nil {
+			return err
+		}
+		return pdb.recordMigration(tx, &m)
+	})
+	if err != nil {
+		return Migration{}, err
 	}
 
-	return nil
+	return m, nil
 }
 
-// ApplyMigration applies a migration and records it
-func (pdb *PostgresDB) ApplyMigration(fileName string, hash string, previousHash string) error {
-	tx, err := pdb.db.Begin()
+// applyWithoutTransaction runs a migration outside a transaction, then records
+// it in a transaction of its own. A session advisory lock is held on one
+// connection throughout, so concurrent applies still link in order.
+func (pdb *PostgresDB) applyWithoutTransaction(query string, m Migration) (Migration, error) {
+	conn, release, err := pdb.conn()
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return Migration{}, err
 	}
+	defer release()
 
-	// Insert migration record
-	query := `
-	insert into rf_migrate.migrations (hash, previous_hash, file_name, date)
-	values ($1, $2, $3, now());`
+	key := pdb.lockKey(applyLock)
+	if _, err := conn.ExecContext(pdb.ctx, `select pg_advisory_lock($1);`, key); err != nil {
+		return Migration{}, fmt.Errorf("failed to lock %s: %w", pdb.tracking, err)
+	}
+	defer conn.ExecContext(context.Background(), `select pg_advisory_unlock($1);`, key) //nolint:errcheck
+
+	if err := runMigration(pdb.ctx, conn, query, &m); err != nil {
+		return Migration{}, err
+	}
 
-	_, err = tx.Exec(query, hash, previousHash, fileName)
+	tx, err := conn.BeginTx(pdb.ctx, nil)
 	if err != nil {
+		return Migration{}, fmt.Errorf("migration %s was applied but could not be recorded: failed to begin transaction: %w", m.FileName, err)
+	}
+	if err := pdb.recordMigration(tx, &m); err != nil {
 		tx.Rollback() //nolint:errcheck
-		return fmt.Errorf("failed to insert migration record: %w", err)
+		return Migration{}, fmt.Errorf("migration %s was applied but could not be recorded: %w", m.FileName, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return Migration{}, fmt.Errorf("migration %s was applied but could not be recorded: failed to commit transaction: %w", m.FileName, err)
 	}
 
-	return tx.Commit()
+	return m, nil
 }
 
-// GetAppliedMigrations returns all applied migrations
-func (pdb *PostgresDB) GetAppliedMigrations() ([]Migration, error) {
-	query := `
-	select hash, previous_hash, file_name, date
-	from rf_migrate.migrations
-	order by date asc;`
+// conn returns a single connection of the handle, so session state such as
+// advisory locks stays on it, and a function that returns it to the pool
+func (pdb *PostgresDB) conn() (SQLHandle, func(), error) {
+	pool, ok := pdb.db.(*sql.DB)
+	if !ok {
+		return pdb.db, func() {}, nil // Already a single connection
+	}
 
-	rows, err := pdb.db.Query(query)
+	conn, err := pool.Conn(pdb.ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query migrations: %w", err)
+		return nil, nil, fmt.Errorf("failed to get connection: %w", err)
 	}
-	defer rows.Close()
+	return conn, func() { conn.Close() }, nil
+}
 
-	var migrations []Migration
-	for rows.Next() {
-		var m Migration
-		if err := rows.Scan(&m.Hash, &m.PreviousHash, &m.FileName, &m.Date); err != nil {
-			return nil, fmt.Errorf("failed to scan migration row: %w", err)
-		}
-		migrations = append(migrations, m)
+// runMigration runs the migration SQL with db and fills in its duration and rows affected
+func runMigration(ctx context.Context, db execer, query string, m *Migration) error {
+	start := time.Now()
+	result, err := db.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to execute migration: %w", err)
 	}
+	m.Duration = time.Since(start)
+	m.RowsAffected, _ = result.RowsAffected() // Not every statement reports a count
+	return nil
+}
+
+// recordMigration inserts the record of a migration, linked to the last one recorded.
+// The caller holds the apply lock.
+func (pdb *PostgresDB) recordMigration(tx *sql.Tx, m *Migration) error {
+	previousQuery := fmt.Sprintf(`
+	select coalesce((select hash from %s order by seq desc limit 1), '');`, pdb.table(""))
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("failed to iterate migration rows: %w", err)
+	if err := tx.QueryRowContext(pdb.ctx, previousQuery).Scan(&m.PreviousHash); err != nil {
+		return fmt.Errorf("failed to get previous migration: %w", err)
 	}
 
-	return migrations, nil
-}
+	insertQuery := fmt.Sprintf(`
+	insert into %s
+		(hash, previous_hash, file_name, date, applied_by, host, duration_ms, tool_version, git_commit)
+	values ($1, $2, $3, now(), $4, $5, $6, $7, $8);`, pdb.table(""))
 
-// RemoveLastMigration removes the last migration from the migrations table
-func (pdb *PostgresDB) RemoveLastMigration() (Migration, error) {
-	tx, err := pdb.db.Begin()
+	_, err := tx.ExecContext(pdb.ctx, insertQuery, m.Hash, m.PreviousHash, m.FileName,
+		m.AppliedBy, m.Host, m.Duration.Milliseconds(), m.ToolVersion, m.GitCommit)
 	if err != nil {
-		return Migration{}, fmt.Errorf("failed to begin transaction: %w", err)
+		return fmt.Errorf("failed to insert migration record: %w", err)
 	}
+	return nil
+}
 
-	// Get the latest migration
-	query := `
-	select hash, previous_hash, file_name, date
-	from rf_migrate.migrations
-	order by date desc
-	limit 1;`
+// GetAppliedMigrations returns all applied migrations
+func (pdb *PostgresDB) GetAppliedMigrations() ([]Migration, error) {
+	return pdb.GetAppliedMigrationsContext(pdb.ctx)
+}
+
+// GetAppliedMigrationsContext returns all applied migrations, running the query with ctx
+func (pdb *PostgresDB) GetAppliedMigrationsContext(ctx context.Context) ([]Migration, error) {
+	query := fmt.Sprintf(`
+	select seq, hash, coalesce(previous_hash, ''), file_name, date,
+		coalesce(applied_by, ''), coalesce(host, ''), coalesce(duration_ms, 0),
+		coalesce(tool_version, ''), coalesce(git_commit, '')
+	from %s
+	order by seq asc;`, pdb.table(""))
 
-	var m Migration
-	err = tx.QueryRow(query).Scan(&m.Hash, &m.PreviousHash, &m.FileName, &m.Date)
+	rows, err := pdb.db.QueryContext(ctx, query)
 	if err != nil {
-		tx.Rollback() //nolint:errcheck
-		return Migration{}, fmt.Errorf("failed to get last migration: %w", err)
+		return nil, fmt.Errorf("failed to query migrations: %w", err)
 	}
 
-	// Delete the migration
-	deleteQuery := `delete from rf_migrate.migrations where hash = $1;`
-	_, err = tx.Exec(deleteQuery, m.Hash)
+	return scanMigrations(rows)
+}
+
+// RemoveMigrations removes the given migrations from the migrations table in a single transaction.
+// Nothing is removed if any of the hashes is not recorded.
+func (pdb *PostgresDB) RemoveMigrations(hashes []string) error {
+	deleteQuery := fmt.Sprintf(`delete from %s where hash = $1;`, pdb.table(""))
+	return removeHashes(pdb.ctx, pdb.db, deleteQuery, hashes)
+}
+
+// DescribeSchema returns a sorted, line-per-object description of the user schema.
+// Tables, columns and indexes are included; system schemas and the bookkeeping tables are skipped.
+func (pdb *PostgresDB) DescribeSchema() ([]string, error) {
+	query := `
+	select format('column %I.%I.%I %s%s', table_schema, table_name, column_name, data_type,
+		case when is_nullable = 'NO' then ' not null' else '' end)
+	from information_schema.columns
+	where table_schema not in ('pg_catalog', 'information_schema', 'crdb_internal', 'pg_extension')
+		and not (table_schema = $1 and table_name in ($2, $3, $4))
+	union all
+	select format('index %I.%I %s', schemaname, indexname, indexdef)
+	from pg_indexes
+	where schemaname not in ('pg_catalog', 'information_schema', 'crdb_internal', 'pg_extension')
+		and not (schemaname = $1 and tablename in ($2, $3, $4))
+	order by 1;`
+
+	rows, err := pdb.db.QueryContext(pdb.ctx, query, pdb.tracking.Schema, pdb.tracking.Name, pdb.tracking.Name+"_meta", pdb.tracking.Name+"_lease")
 	if err != nil {
-		tx.Rollback() //nolint:errcheck
-		return Migration{}, fmt.Errorf("failed to delete migration: %w", err)
+		return nil, fmt.Errorf("failed to describe schema: %w", err)
 	}
 
-	if err := tx.Commit(); err != nil {
-		return Migration{}, fmt.Errorf("failed to commit transaction: %w", err)
-	}
+	return scanLines(rows)
+}
 
-	return m, nil
+// fillAuditFields sets the user and host of a migration record when not already set
+func fillAuditFields(m *Migration) {
+	if m.AppliedBy == "" {
+		if u, err := user.Current(); err == nil {
+			m.AppliedBy = u.Username
+		} else {
+			m.AppliedBy = os.Getenv("USER")
+		}
+	}
+	if m.Host == "" {
+		m.Host, _ = os.Hostname()
+	}
 }
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// dialect is a database server that speaks the PostgreSQL protocol
+type dialect int
+
+const (
+	dialectPostgres  dialect = iota
+	dialectCockroach         // CockroachDB: no advisory locks, schema changes are not fully transactional
+)
+
+// String returns the name of the server
+func (d dialect) String() string {
+	if d == dialectCockroach {
+		return "CockroachDB"
+	}
+	return "PostgreSQL"
+}
+
+// detectDialect tells CockroachDB from PostgreSQL by the server version
+func detectDialect(ctx context.Context, db SQLHandle) (dialect, error) {
+	var version string
+	if err := db.QueryRowContext(ctx, `select version();`).Scan(&version); err != nil {
+		return dialectPostgres, fmt.Errorf("failed to read server version: %w", err)
+	}
+	if strings.Contains(version, "CockroachDB") {
+		return dialectCockroach, nil
+	}
+	return dialectPostgres, nil
+}
+
+// lock serializes work between rf-migrate processes
+type lock struct {
+	key        int64  // Advisory lock key on PostgreSQL, see lockKey
+	lease      string // Lease row name on CockroachDB
+	schemaWide bool   // Shared by every tracking table in the schema
+}
+
+// Locks held by rf-migrate. Upgrades may create the schema, so they are
+// serialized schema-wide; applies only wait for the same tracking table.
+var (
+	schemaLock = lock{key: schemaLockKey, lease: "schema", schemaWide: true}
+	applyLock  = lock{key: applyLockKey, lease: "apply"}
+)
+
+// lockKey returns the advisory lock key of l for the tracking table, so
+// independent migration histories in one database do not block each other.
+// Leases need no such key, as each tracking table has its own lease table.
+func (pdb *PostgresDB) lockKey(l lock) int64 {
+	scope := pdb.tracking.String()
+	if l.schemaWide {
+		scope = pdb.tracking.Schema
+	}
+
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d:%s", l.key, scope)
+	return int64(h.Sum64())
+}
+
+// inLockedTx runs fn in a transaction while holding l, and commits if fn succeeds.
+// PostgreSQL takes an advisory lock in the transaction. CockroachDB has none, so a
+// lease row is taken before the transaction begins and released after it ends;
+// the transaction renews it before committing, and fails if the lease was lost.
+func (pdb *PostgresDB) inLockedTx(l lock, fn func(tx *sql.Tx) error) error {
+	if pdb.dialect == dialectCockroach {
+		held, err := pdb.acquireLease(l.lease)
+		if err != nil {
+			return err
+		}
+		defer held.release()
+
+		return pdb.inTx(func(tx *sql.Tx) error {
+			if err := fn(tx); err != nil {
+				return err
+			}
+			return held.renew(tx)
+		})
+	}
+
+	return pdb.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(pdb.ctx, `select pg_advisory_xact_lock($1);`, pdb.lockKey(l)); err != nil {
+			return fmt.Errorf("failed to lock %s: %w", pdb.tracking, err)
+		}
+		return fn(tx)
+	})
+}
+
+// inTx runs fn in a transaction, and commits if fn succeeds
+func (pdb *PostgresDB) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := pdb.db.BeginTx(pdb.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback() //nolint:errcheck
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
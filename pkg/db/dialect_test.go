@@ -0,0 +1,34 @@
+package db
+
+import "testing"
+
+func TestLockKey(t *testing.T) {
+	billing := &PostgresDB{tracking: TrackingTable{Schema: "rf_migrate", Name: "billing"}}
+	accounts := &PostgresDB{tracking: TrackingTable{Schema: "rf_migrate", Name: "accounts"}}
+	public := &PostgresDB{tracking: TrackingTable{Schema: "public", Name: "billing"}}
+
+	tests := []struct {
+		name  string
+		a, b  *PostgresDB
+		l     lock
+		equal bool
+	}{
+		{name: "same table", a: billing, b: &PostgresDB{tracking: billing.tracking}, l: applyLock, equal: true},
+		{name: "other table", a: billing, b: accounts, l: applyLock, equal: false},
+		{name: "other schema", a: billing, b: public, l: applyLock, equal: false},
+		{name: "schema lock in the same schema", a: billing, b: accounts, l: schemaLock, equal: true},
+		{name: "schema lock in another schema", a: billing, b: public, l: schemaLock, equal: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.lockKey(tt.l) == tt.b.lockKey(tt.l); got != tt.equal {
+				t.Errorf("keys equal = %v, want %v", got, tt.equal)
+			}
+		})
+	}
+
+	if billing.lockKey(schemaLock) == billing.lockKey(applyLock) {
+		t.Error("schema and apply locks share a key")
+	}
+}
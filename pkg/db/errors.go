@@ -0,0 +1,36 @@
+package db
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+)
+
+// undefinedTable is the SQLSTATE of a missing table on PostgreSQL and CockroachDB
+const undefinedTable = "42P01"
+
+// IsMissingTable reports whether err is caused by a table that does not exist,
+// such as the tracking table of a migration set that has never been migrated
+func IsMissingTable(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == undefinedTable
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == undefinedTable
+	}
+
+	// 1146: table doesn't exist, 1049: unknown database
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1146 || mysqlErr.Number == 1049
+	}
+
+	// SQLite reports missing tables only in the message
+	return err != nil && strings.Contains(err.Error(), "no such table")
+}
@@ -0,0 +1,37 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+)
+
+func TestIsMissingTable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "pq undefined table", err: &pq.Error{Code: undefinedTable}, want: true},
+		{name: "pq other", err: &pq.Error{Code: "42601"}, want: false},
+		{name: "pgx undefined table", err: fmt.Errorf("query failed: %w", &pgconn.PgError{Code: undefinedTable}), want: true},
+		{name: "mysql missing table", err: &mysql.MySQLError{Number: 1146}, want: true},
+		{name: "mysql unknown database", err: &mysql.MySQLError{Number: 1049}, want: true},
+		{name: "mysql other", err: &mysql.MySQLError{Number: 1064}, want: false},
+		{name: "sqlite", err: errors.New("no such table: migrations"), want: true},
+		{name: "other", err: errors.New("connection refused"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsMissingTable(tt.err); got != tt.want {
+				t.Errorf("IsMissingTable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
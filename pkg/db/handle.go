@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// SQLHandle is the part of *sql.DB and *sql.Conn that PostgresDB uses
+type SQLHandle interface {
+	PingContext(ctx context.Context) error
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// NewPostgresDBFromSQL wraps an existing *sql.DB or *sql.Conn connected to PostgreSQL
+// or CockroachDB, so connection setup such as custom dialers, token authentication
+// and tracing stays with the caller. Close leaves the handle open for the caller to
+// close. Of the options only WithContext applies, since the driver is already set up.
+//
+// On CockroachDB, locks are leases renewed on a connection of their own while a
+// migration runs, so a *sql.Conn and a *sql.DB limited to one open connection
+// are rejected.
+func NewPostgresDBFromSQL(handle SQLHandle, tracking TrackingTable, opts ...Option) (DB, error) {
+	if handle == nil {
+		return nil, fmt.Errorf("database handle is required")
+	}
+
+	o := options{ctx: context.Background()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return newPostgresDB(handle, tracking, o)
+}
+
+// newPostgresDB checks the connection and detects which server it is talking to
+func newPostgresDB(handle SQLHandle, tracking TrackingTable, o options) (*PostgresDB, error) {
+	// Test connection
+	if err := handle.PingContext(o.ctx); err != nil {
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	dialect, err := detectDialect(o.ctx, handle)
+	if err != nil {
+		return nil, err
+	}
+	if dialect == dialectCockroach {
+		if err := checkLeaseConnections(handle); err != nil {
+			return nil, err
+		}
+	}
+
+	return &PostgresDB{db: handle, tracking: tracking, ctx: o.ctx, dialect: dialect}, nil
+}
+
+// checkLeaseConnections rejects handles that cannot renew a lease while a
+// transaction holds their only connection
+func checkLeaseConnections(handle SQLHandle) error {
+	switch h := handle.(type) {
+	case *sql.Conn:
+		return fmt.Errorf("a *sql.Conn cannot be used with CockroachDB, since leases are renewed on another connection; pass the *sql.DB instead")
+	case *sql.DB:
+		if h.Stats().MaxOpenConnections == 1 {
+			return fmt.Errorf("a *sql.DB limited to one open connection cannot be used with CockroachDB, since leases are renewed on another connection; allow at least two")
+		}
+	}
+	return nil
+}
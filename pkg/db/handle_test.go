@@ -0,0 +1,45 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+)
+
+// unusedConnector opens pools that are never connected
+type unusedConnector struct{}
+
+func (unusedConnector) Connect(context.Context) (driver.Conn, error) {
+	return nil, errors.New("not connected")
+}
+func (unusedConnector) Driver() driver.Driver { return nil }
+
+func TestCheckLeaseConnections(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxOpenConns int
+		wantErr      bool
+	}{
+		{name: "unlimited", maxOpenConns: 0},
+		{name: "two", maxOpenConns: 2},
+		{name: "one", maxOpenConns: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := sql.OpenDB(unusedConnector{})
+			defer pool.Close()
+			pool.SetMaxOpenConns(tt.maxOpenConns)
+
+			if err := checkLeaseConnections(pool); (err != nil) != tt.wantErr {
+				t.Errorf("checkLeaseConnections() error = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := checkLeaseConnections(&sql.Conn{}); err == nil {
+		t.Error("checkLeaseConnections(*sql.Conn) succeeded, want an error")
+	}
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMySQLLockName(t *testing.T) {
+	long := strings.Repeat("x", 60)
+
+	tests := []struct {
+		name     string
+		tracking TrackingTable
+		want     string
+	}{
+		{name: "default", tracking: DefaultTrackingTable, want: "rf_migrate_apply:rf_migrate.migrations"},
+		{name: "other set", tracking: TrackingTable{Schema: "rf_migrate", Name: "accounts"}, want: "rf_migrate_apply:rf_migrate.accounts"},
+		{name: "too long", tracking: TrackingTable{Schema: "rf_migrate", Name: long}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mdb := &MySQLDB{tracking: tt.tracking}
+			got := mdb.lockName(mysqlApplyLock)
+			if len(got) > mysqlMaxLockName {
+				t.Fatalf("lockName() = %q, longer than %d", got, mysqlMaxLockName)
+			}
+			if tt.want != "" && got != tt.want {
+				t.Errorf("lockName() = %q, want %q", got, tt.want)
+			}
+			if !strings.HasPrefix(got, mysqlApplyLock+":") {
+				t.Errorf("lockName() = %q, want the lock as prefix", got)
+			}
+		})
+	}
+
+	a := &MySQLDB{tracking: TrackingTable{Schema: "rf_migrate", Name: long + "a"}}
+	b := &MySQLDB{tracking: TrackingTable{Schema: "rf_migrate", Name: long + "b"}}
+	if a.lockName(mysqlApplyLock) == b.lockName(mysqlApplyLock) {
+		t.Error("long tracking tables share a lock name")
+	}
+}
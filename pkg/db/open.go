@@ -0,0 +1,189 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/lib/pq"
+)
+
+// Drivers a PostgreSQL database can be opened with
+const (
+	DriverPQ  = "pq"  // github.com/lib/pq, the default unless pgx is needed
+	DriverPgx = "pgx" // github.com/jackc/pgx/v5
+)
+
+// pgxScheme selects the pgx driver from the URL alone, e.g. pgx://user@host/db
+const pgxScheme = "pgx://"
+
+// sqliteScheme selects SQLite; the rest of the URL is the database file,
+// e.g. sqlite://dev.db or sqlite:///var/lib/app/app.db
+const sqliteScheme = "sqlite://"
+
+// Notice is a message the server sends alongside a statement, such as a
+// RAISE NOTICE or "relation already exists, skipping"
+type Notice struct {
+	Severity string
+	Message  string
+}
+
+// Option configures a database opened with Open
+type Option func(*options)
+
+type options struct {
+	driver   string
+	ctx      context.Context
+	onNotice func(Notice)
+}
+
+// WithDriver selects the driver: DriverPQ or DriverPgx
+func WithDriver(driver string) Option {
+	return func(o *options) {
+		o.driver = driver
+	}
+}
+
+// WithContext runs every statement with ctx, so a running migration is
+// cancelled on the server and rolled back when ctx is done
+func WithContext(ctx context.Context) Option {
+	return func(o *options) {
+		o.ctx = ctx
+	}
+}
+
+// WithNoticeHandler calls handler for every notice the server sends
+func WithNoticeHandler(handler func(Notice)) Option {
+	return func(o *options) {
+		o.onNotice = handler
+	}
+}
+
+// Open connects to a database that records migrations in the given tracking table.
+// PostgreSQL uses the driver selected with WithDriver. Without one it uses lib/pq,
+// or pgx for a pgx:// URL or a connection configured through a pg_service.conf
+// service, which lib/pq cannot read. Both drivers behave the same towards rf-migrate. A sqlite:// URL opens a SQLite
+// database file and a mysql:// URL a MySQL or MariaDB database instead.
+func Open(url string, tracking TrackingTable, opts ...Option) (DB, error) {
+	o := options{ctx: context.Background()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if path, ok := strings.CutPrefix(url, sqliteScheme); ok {
+		return openSQLite(path, tracking, o)
+	}
+	if strings.HasPrefix(url, mysqlScheme) {
+		return openMySQL(url, tracking, o)
+	}
+	driver, err := selectDriver(o.driver, url)
+	if err != nil {
+		return nil, err
+	}
+	if rest, ok := strings.CutPrefix(url, pgxScheme); ok {
+		url = "postgres://" + rest
+	}
+
+	var db *sql.DB
+	switch driver {
+	case DriverPQ:
+		db, err = openPQ(url, o.onNotice)
+	case DriverPgx:
+		db, err = openPgx(url, o.onNotice)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	pdb, err := newPostgresDB(db, tracking, o)
+	if err != nil {
+		db.Close() //nolint:errcheck
+		return nil, err
+	}
+	pdb.closer = db
+
+	return pdb, nil
+}
+
+// selectDriver returns the driver for a PostgreSQL URL: the configured one, or
+// pgx when the URL needs it and lib/pq otherwise. A configured lib/pq is an
+// error where pgx is needed, rather than silently ignoring part of the settings.
+func selectDriver(configured string, url string) (string, error) {
+	var needsPgx string
+	switch {
+	case strings.HasPrefix(url, pgxScheme):
+		needsPgx = "a pgx:// URL"
+	case usesServiceFile(url):
+		needsPgx = "connections through a pg_service.conf service"
+	}
+
+	switch configured {
+	case "":
+		if needsPgx != "" {
+			return DriverPgx, nil
+		}
+		return DriverPQ, nil
+	case DriverPQ:
+		if needsPgx != "" {
+			return "", fmt.Errorf("the %s driver cannot open %s; use the %s driver", DriverPQ, needsPgx, DriverPgx)
+		}
+		return DriverPQ, nil
+	case DriverPgx:
+		return DriverPgx, nil
+	default:
+		return "", fmt.Errorf("unknown database driver %q (use %s or %s)", configured, DriverPQ, DriverPgx)
+	}
+}
+
+// usesServiceFile reports whether connection settings come from pg_service.conf,
+// through a service parameter or the PGSERVICE and PGSERVICEFILE environment variables
+func usesServiceFile(url string) bool {
+	if os.Getenv("PGSERVICE") != "" || os.Getenv("PGSERVICEFILE") != "" {
+		return true
+	}
+	for _, field := range strings.FieldsFunc(url, func(r rune) bool { return r == ' ' || r == '?' || r == '&' }) {
+		if strings.HasPrefix(field, "service=") {
+			return true
+		}
+	}
+	return false
+}
+
+// openPQ opens a connection pool using lib/pq
+func openPQ(url string, onNotice func(Notice)) (*sql.DB, error) {
+	pqConnector, err := pq.NewConnector(url)
+	if err != nil {
+		return nil, err
+	}
+
+	var connector driver.Connector = pqConnector
+	if onNotice != nil {
+		connector = pq.ConnectorWithNoticeHandler(connector, func(notice *pq.Error) {
+			onNotice(Notice{Severity: notice.Severity, Message: notice.Message})
+		})
+	}
+
+	return sql.OpenDB(connector), nil
+}
+
+// openPgx opens a connection pool using pgx through its database/sql adapter
+func openPgx(url string, onNotice func(Notice)) (*sql.DB, error) {
+	config, err := pgx.ParseConfig(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if onNotice != nil {
+		config.OnNotice = func(_ *pgconn.PgConn, notice *pgconn.Notice) {
+			onNotice(Notice{Severity: notice.Severity, Message: notice.Message})
+		}
+	}
+
+	return stdlib.OpenDB(*config), nil
+}
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelectDriver(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured string
+		url        string
+		service    string // PGSERVICE
+		want       string
+		wantErr    string
+	}{
+		{name: "default", url: "postgres://localhost/app", want: DriverPQ},
+		{name: "explicit pgx", configured: DriverPgx, url: "postgres://localhost/app", want: DriverPgx},
+		{name: "pgx url", url: "pgx://localhost/app", want: DriverPgx},
+		{name: "service in url", url: "service=app", want: DriverPgx},
+		{name: "service in environment", url: "postgres://localhost/app", service: "app", want: DriverPgx},
+		{name: "explicit pgx with service", configured: DriverPgx, url: "service=app", want: DriverPgx},
+		{name: "explicit pq with service in environment", configured: DriverPQ, url: "postgres://localhost/app", service: "app", wantErr: "pg_service.conf"},
+		{name: "explicit pq with service in url", configured: DriverPQ, url: "host=localhost service=app", wantErr: "pg_service.conf"},
+		{name: "explicit pq with pgx url", configured: DriverPQ, url: "pgx://localhost/app", wantErr: "pgx:// URL"},
+		{name: "unknown", configured: "odbc", url: "postgres://localhost/app", wantErr: `unknown database driver "odbc"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PGSERVICE", tt.service)
+			t.Setenv("PGSERVICEFILE", "")
+
+			got, err := selectDriver(tt.configured, tt.url)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("selectDriver() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("selectDriver() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("selectDriver() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
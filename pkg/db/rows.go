@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Helpers shared by the backends, which differ only in their SQL
+
+// scanMigrations reads and closes rows of seq, hash, previous_hash, file_name,
+// date, applied_by, host, duration_ms, tool_version and git_commit
+func scanMigrations(rows *sql.Rows) ([]Migration, error) {
+	defer rows.Close()
+
+	var migrations []Migration
+	for rows.Next() {
+		var m Migration
+		var durationMs int64
+		if err := rows.Scan(&m.Seq, &m.Hash, &m.PreviousHash, &m.FileName, &m.Date,
+			&m.AppliedBy, &m.Host, &durationMs, &m.ToolVersion, &m.GitCommit); err != nil {
+			return nil, fmt.Errorf("failed to scan migration row: %w", err)
+		}
+		m.Duration = time.Duration(durationMs) * time.Millisecond
+		migrations = append(migrations, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate migration rows: %w", err)
+	}
+
+	return migrations, nil
+}
+
+// scanLines reads and closes rows of a single text column, such as the output
+// of a DescribeSchema query
+func scanLines(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, fmt.Errorf("failed to scan schema row: %w", err)
+		}
+		lines = append(lines, line)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate schema rows: %w", err)
+	}
+
+	return lines, nil
+}
+
+// removeHashes runs deleteQuery, which takes a hash as its only parameter, for
+// each hash in a single transaction. Nothing is removed if any of the hashes
+// is not recorded.
+func removeHashes(ctx context.Context, db SQLHandle, deleteQuery string, hashes []string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	for _, hash := range hashes {
+		result, err := tx.ExecContext(ctx, deleteQuery, hash)
+		if err != nil {
+			tx.Rollback() //nolint:errcheck
+			return fmt.Errorf("failed to delete migration: %w", err)
+		}
+
+		count, err := result.RowsAffected()
+		if err != nil {
+			tx.Rollback() //nolint:errcheck
+			return fmt.Errorf("failed to delete migration: %w", err)
+		}
+		if count != 1 {
+			tx.Rollback() //nolint:errcheck
+			return fmt.Errorf("migration with hash %s is not recorded", hash)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
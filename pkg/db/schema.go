@@ -0,0 +1,162 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Advisory lock keys used by rf-migrate, combined with the tracking table by lockKey
+const (
+	schemaLockKey = 0x72665f6d // "rf_m", held while upgrading bookkeeping tables
+	applyLockKey  = 0x72665f61 // "rf_a", held while recording a migration
+)
+
+// schemaUpgrades brings the bookkeeping tables up to date.
+// Step i upgrades schema version i to i+1 and is recorded in the meta table.
+// Steps must be idempotent: databases created before the meta table existed
+// start at version 0 whatever their layout.
+//
+// Steps are templates: {migrations} is the tracking table, {sequence} its
+// sequence, {sequence_name} the sequence as a string literal and {sequence_index}
+// the unqualified name of the unique index on the sequence column.
+var schemaUpgrades = []string{
+	// 1: migrations table
+	`create table if not exists {migrations} (
+		hash text primary key,
+		previous_hash text,
+		file_name text not null,
+		date timestamp not null default now()
+	);`,
+
+	// 2: audit metadata
+	`alter table {migrations}
+		add column if not exists applied_by text,
+		add column if not exists host text,
+		add column if not exists duration_ms bigint,
+		add column if not exists tool_version text,
+		add column if not exists git_commit text;`,
+
+	// 3: apply order sequence; existing rows are numbered by date, then file name
+	`alter table {migrations} add column if not exists seq bigint;
+	create sequence if not exists {sequence} owned by {migrations}.seq;
+	update {migrations} m
+	set seq = o.seq
+	from (
+		select hash, coalesce((select max(seq) from {migrations}), 0)
+			+ row_number() over (order by date, file_name) as seq
+		from {migrations}
+		where seq is null
+	) o
+	where m.hash = o.hash;
+	select setval({sequence_name}, coalesce((select max(seq) from {migrations}), 0) + 1, false);
+	alter table {migrations}
+		alter column seq set default nextval({sequence_name}),
+		alter column seq set not null;
+	create unique index if not exists {sequence_index} on {migrations} (seq);`,
+}
+
+// EnsureMigrationsTable ensures that the migrations table exists and upgrades
+// the bookkeeping tables to the current layout. Upgrades run in one transaction
+// under an advisory lock, so concurrent processes upgrade only once.
+func (pdb *PostgresDB) EnsureMigrationsTable() error {
+	if pdb.dialect == dialectCockroach {
+		return pdb.ensureCockroachTables()
+	}
+
+	// Serialize upgrades between concurrent rf-migrate processes
+	return pdb.inLockedTx(schemaLock, func(tx *sql.Tx) error {
+		// Create schema if missing; only checking first lets existing schemas be
+		// used by roles that may not create schemas
+		var schemaExists bool
+		if err := tx.QueryRowContext(pdb.ctx, `select exists (select 1 from pg_namespace where nspname = $1);`, pdb.tracking.Schema).Scan(&schemaExists); err != nil {
+			return fmt.Errorf("failed to look up schema: %w", err)
+		}
+		if !schemaExists {
+			if _, err := tx.ExecContext(pdb.ctx, `create schema `+pq.QuoteIdentifier(pdb.tracking.Schema)+`;`); err != nil {
+				return fmt.Errorf("failed to create schema: %w", err)
+			}
+		}
+
+		// Create meta table if not exists
+		if _, err := tx.ExecContext(pdb.ctx, pdb.metaTableQuery()); err != nil {
+			return fmt.Errorf("failed to create meta table: %w", err)
+		}
+
+		// Find the installed version
+		version, err := pdb.schemaVersion(tx)
+		if err != nil {
+			return err
+		}
+		if version > len(schemaUpgrades) {
+			return fmt.Errorf("bookkeeping schema version %d of %s is newer than this rf-migrate supports (%d); please upgrade rf-migrate", version, pdb.tracking, len(schemaUpgrades))
+		}
+		if version == len(schemaUpgrades) {
+			return nil
+		}
+
+		// Run the missing upgrade steps
+		expand := pdb.upgradeReplacer()
+		for i := version; i < len(schemaUpgrades); i++ {
+			if _, err := tx.ExecContext(pdb.ctx, expand.Replace(schemaUpgrades[i])); err != nil {
+				return fmt.Errorf("failed to upgrade bookkeeping schema to version %d: %w", i+1, err)
+			}
+		}
+
+		return pdb.setSchemaVersion(tx, len(schemaUpgrades))
+	})
+}
+
+// metaTableQuery returns the statement creating the meta table if it does not exist
+func (pdb *PostgresDB) metaTableQuery() string {
+	return fmt.Sprintf(`
+	create table if not exists %s (
+		key text primary key,
+		value text not null
+	);`, pdb.table("_meta"))
+}
+
+// upgradeReplacer expands the placeholders of upgrade steps
+func (pdb *PostgresDB) upgradeReplacer() *strings.Replacer {
+	return strings.NewReplacer(
+		"{migrations}", pdb.table(""),
+		"{sequence}", pdb.table("_seq"),
+		"{sequence_name}", pq.QuoteLiteral(pdb.table("_seq")),
+		"{sequence_index}", pq.QuoteIdentifier(pdb.tracking.Name+"_seq_key"),
+	)
+}
+
+// setSchemaVersion records the installed bookkeeping schema version
+func (pdb *PostgresDB) setSchemaVersion(tx *sql.Tx, version int) error {
+	versionQuery := fmt.Sprintf(`
+	insert into %s (key, value) values ('schema_version', $1)
+	on conflict (key) do update set value = excluded.value;`, pdb.table("_meta"))
+
+	if _, err := tx.ExecContext(pdb.ctx, versionQuery, strconv.Itoa(version)); err != nil {
+		return fmt.Errorf("failed to record bookkeeping schema version: %w", err)
+	}
+	return nil
+}
+
+// schemaVersion returns the installed bookkeeping schema version, or 0 if none is recorded
+func (pdb *PostgresDB) schemaVersion(tx *sql.Tx) (int, error) {
+	var value string
+	query := fmt.Sprintf(`select value from %s where key = 'schema_version';`, pdb.table("_meta"))
+	err := tx.QueryRowContext(pdb.ctx, query).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read bookkeeping schema version: %w", err)
+	}
+
+	version, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bookkeeping schema version %q: %w", value, err)
+	}
+	return version, nil
+}
@@ -0,0 +1,278 @@
+//go:build cgo
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3" // SQLite driver, requires cgo
+)
+
+// sqliteUpgrades brings the SQLite bookkeeping tables up to date, like schemaUpgrades
+// does for PostgreSQL. {migrations} is the tracking table.
+var sqliteUpgrades = []string{
+	// 1: migrations table with audit metadata and apply order
+	`create table if not exists {migrations} (
+		seq integer primary key,
+		hash text not null unique,
+		previous_hash text,
+		file_name text not null,
+		date timestamp not null default current_timestamp,
+		applied_by text,
+		host text,
+		duration_ms integer,
+		tool_version text,
+		git_commit text
+	);`,
+}
+
+// SQLiteDB is a SQLite implementation of DB. SQLite has no schemas, so the
+// tracking table is a plain table named <schema>_<name>, rf_migrate_migrations
+// by default. Write transactions take the database lock when they begin,
+// which serializes concurrent applies the way advisory locks do on PostgreSQL.
+type SQLiteDB struct {
+	db    *sql.DB
+	table string
+	ctx   context.Context
+}
+
+// openSQLite opens the SQLite database file at path
+func openSQLite(path string, tracking TrackingTable, o options) (DB, error) {
+	if path == "" {
+		return nil, fmt.Errorf("sqlite URL must name a database file, e.g. %sdev.db", sqliteScheme)
+	}
+
+	// Lock the database at the start of each transaction rather than at its first write
+	dsn := path
+	if !strings.Contains(dsn, "_txlock=") {
+		if strings.Contains(dsn, "?") {
+			dsn += "&_txlock=immediate"
+		} else {
+			dsn += "?_txlock=immediate"
+		}
+	}
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	// A single connection keeps :memory: databases alive and avoids busy errors within the process
+	db.SetMaxOpenConns(1)
+
+	// Test connection
+	if err := db.PingContext(o.ctx); err != nil {
+		db.Close() //nolint:errcheck
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	table := tracking.Name
+	if tracking.Schema != "" {
+		table = tracking.Schema + "_" + tracking.Name
+	}
+
+	return &SQLiteDB{db: db, table: table, ctx: o.ctx}, nil
+}
+
+// quoted returns the quoted name of a bookkeeping table.
+// The suffix is appended to the tracking table name, so "" is the tracking table itself.
+func (sdb *SQLiteDB) quoted(suffix string) string {
+	return `"` + strings.ReplaceAll(sdb.table+suffix, `"`, `""`) + `"`
+}
+
+// Execute runs a SQL query with no rows returned
+func (sdb *SQLiteDB) Execute(query string) error {
+	_, err := sdb.db.ExecContext(sdb.ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+	return nil
+}
+
+// Query runs a SQL query with rows returned
+func (sdb *SQLiteDB) Query(query string) (*sql.Rows, error) {
+	rows, err := sdb.db.QueryContext(sdb.ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query database: %w", err)
+	}
+	return rows, nil
+}
+
+// Close closes the database connection
+func (sdb *SQLiteDB) Close() error {
+	return sdb.db.Close()
+}
+
+// EnsureMigrationsTable ensures that the migrations table exists and upgrades
+// the bookkeeping tables to the current layout in one transaction
+func (sdb *SQLiteDB) EnsureMigrationsTable() error {
+	tx, err := sdb.db.BeginTx(sdb.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	// Create meta table holding the bookkeeping schema version
+	metaQuery := fmt.Sprintf(`
+	create table if not exists %s (
+		key text primary key,
+		value text not null
+	);`, sdb.quoted("_meta"))
+
+	if _, err := tx.ExecContext(sdb.ctx, metaQuery); err != nil {
+		tx.Rollback() //nolint:errcheck
+		return fmt.Errorf("failed to create meta table: %w", err)
+	}
+
+	var version int
+	var value string
+	versionQuery := fmt.Sprintf(`select value from %s where key = 'schema_version';`, sdb.quoted("_meta"))
+	err = tx.QueryRowContext(sdb.ctx, versionQuery).Scan(&value)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		tx.Rollback() //nolint:errcheck
+		return fmt.Errorf("failed to read schema version: %w", err)
+	default:
+		if version, err = strconv.Atoi(value); err != nil {
+			tx.Rollback() //nolint:errcheck
+			return fmt.Errorf("invalid schema version %q: %w", value, err)
+		}
+	}
+	if version > len(sqliteUpgrades) {
+		tx.Rollback() //nolint:errcheck
+		return fmt.Errorf("bookkeeping tables are at version %d, newer than this rf-migrate supports (%d); upgrade rf-migrate", version, len(sqliteUpgrades))
+	}
+
+	// Run missing upgrade steps
+	expand := strings.NewReplacer("{migrations}", sdb.quoted(""))
+	for i := version; i < len(sqliteUpgrades); i++ {
+		if _, err := tx.ExecContext(sdb.ctx, expand.Replace(sqliteUpgrades[i])); err != nil {
+			tx.Rollback() //nolint:errcheck
+			return fmt.Errorf("failed to upgrade bookkeeping tables to version %d: %w", i+1, err)
+		}
+	}
+
+	upsertQuery := fmt.Sprintf(`
+	insert into %s (key, value) values ('schema_version', ?)
+	on conflict (key) do update set value = excluded.value;`, sdb.quoted("_meta"))
+
+	if _, err := tx.ExecContext(sdb.ctx, upsertQuery, strconv.Itoa(len(sqliteUpgrades))); err != nil {
+		tx.Rollback() //nolint:errcheck
+		return fmt.Errorf("failed to record schema version: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// ApplyMigration runs a migration and records it in a single transaction,
+// so a failing migration leaves neither schema changes nor a record behind.
+// A NoTransaction migration, such as vacuum, runs before the record transaction begins.
+func (sdb *SQLiteDB) ApplyMigration(query string, m Migration) (Migration, error) {
+	fillAuditFields(&m)
+
+	if m.NoTransaction {
+		if err := runMigration(sdb.ctx, sdb.db, query, &m); err != nil {
+			return Migration{}, err
+		}
+	}
+
+	tx, err := sdb.db.BeginTx(sdb.ctx, nil)
+	if err != nil {
+		return Migration{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	previousQuery := fmt.Sprintf(`
+	select coalesce((select hash from %s order by seq desc limit 1), '');`, sdb.quoted(""))
+
+	if err := tx.QueryRowContext(sdb.ctx, previousQuery).Scan(&m.PreviousHash); err != nil {
+		tx.Rollback() //nolint:errcheck
+		return Migration{}, fmt.Errorf("failed to get previous migration: %w", err)
+	}
+
+	// Run the migration
+	if !m.NoTransaction {
+		if err := runMigration(sdb.ctx, tx, query, &m); err != nil {
+			tx.Rollback() //nolint:errcheck
+			return Migration{}, err
+		}
+	}
+
+	// Insert migration record
+	insertQuery := fmt.Sprintf(`
+	insert into %s
+		(hash, previous_hash, file_name, date, applied_by, host, duration_ms, tool_version, git_commit)
+	values (?, ?, ?, current_timestamp, ?, ?, ?, ?, ?);`, sdb.quoted(""))
+
+	_, err = tx.ExecContext(sdb.ctx, insertQuery, m.Hash, m.PreviousHash, m.FileName,
+		m.AppliedBy, m.Host, m.Duration.Milliseconds(), m.ToolVersion, m.GitCommit)
+	if err != nil {
+		tx.Rollback() //nolint:errcheck
+		return Migration{}, fmt.Errorf("failed to insert migration record: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Migration{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return m, nil
+}
+
+// GetAppliedMigrations returns all applied migrations
+func (sdb *SQLiteDB) GetAppliedMigrations() ([]Migration, error) {
+	return sdb.GetAppliedMigrationsContext(sdb.ctx)
+}
+
+// GetAppliedMigrationsContext returns all applied migrations, running the query with ctx
+func (sdb *SQLiteDB) GetAppliedMigrationsContext(ctx context.Context) ([]Migration, error) {
+	query := fmt.Sprintf(`
+	select seq, hash, coalesce(previous_hash, ''), file_name, date,
+		coalesce(applied_by, ''), coalesce(host, ''), coalesce(duration_ms, 0),
+		coalesce(tool_version, ''), coalesce(git_commit, '')
+	from %s
+	order by seq asc;`, sdb.quoted(""))
+
+	rows, err := sdb.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query migrations: %w", err)
+	}
+
+	return scanMigrations(rows)
+}
+
+// RemoveMigrations removes the given migrations from the migrations table in a single transaction.
+// Nothing is removed if any of the hashes is not recorded.
+func (sdb *SQLiteDB) RemoveMigrations(hashes []string) error {
+	deleteQuery := fmt.Sprintf(`delete from %s where hash = ?;`, sdb.quoted(""))
+	return removeHashes(sdb.ctx, sdb.db, deleteQuery, hashes)
+}
+
+// DescribeSchema returns a sorted, line-per-object description of the user schema.
+// Tables, columns and indexes are included; SQLite's own and the bookkeeping tables are skipped.
+func (sdb *SQLiteDB) DescribeSchema() ([]string, error) {
+	query := `
+	select 'column ' || m.name || '.' || p.name || ' ' || lower(p.type)
+		|| case when p."notnull" then ' not null' else '' end
+	from sqlite_master m join pragma_table_info(m.name) p
+	where m.type = 'table' and m.name not like 'sqlite_%' and m.name not in (?1, ?2)
+	union all
+	select 'index ' || name || ' ' || coalesce(sql, '(automatic)')
+	from sqlite_master
+	where type = 'index' and tbl_name not in (?1, ?2)
+	order by 1;`
+
+	rows, err := sdb.db.QueryContext(sdb.ctx, query, sdb.table, sdb.table+"_meta")
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe schema: %w", err)
+	}
+
+	return scanLines(rows)
+}
@@ -0,0 +1,10 @@
+//go:build !cgo
+
+package db
+
+import "errors"
+
+// openSQLite fails: the SQLite driver needs cgo, and this binary was built without it
+func openSQLite(path string, tracking TrackingTable, o options) (DB, error) {
+	return nil, errors.New("SQLite is not supported by this build of rf-migrate; build it with CGO_ENABLED=1")
+}
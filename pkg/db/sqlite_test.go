@@ -0,0 +1,75 @@
+//go:build cgo
+
+package db
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteTracking(t *testing.T) {
+	database, err := Open("sqlite://"+filepath.Join(t.TempDir(), "test.db"), DefaultTrackingTable)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer database.Close()
+
+	if _, err := database.GetAppliedMigrations(); !IsMissingTable(err) {
+		t.Fatalf("GetAppliedMigrations() before EnsureMigrationsTable error = %v, want a missing table", err)
+	}
+
+	// Upgrading an up-to-date layout is a no-op
+	for range 2 {
+		if err := database.EnsureMigrationsTable(); err != nil {
+			t.Fatalf("EnsureMigrationsTable() error = %v", err)
+		}
+	}
+
+	first, err := database.ApplyMigration("create table a (id integer);", Migration{Hash: "h1", FileName: "000001_a.sql"})
+	if err != nil {
+		t.Fatalf("ApplyMigration() error = %v", err)
+	}
+	second, err := database.ApplyMigration("create table b (id integer);", Migration{Hash: "h2", FileName: "000002_b.sql"})
+	if err != nil {
+		t.Fatalf("ApplyMigration() error = %v", err)
+	}
+	if first.PreviousHash != "" || second.PreviousHash != "h1" {
+		t.Errorf("previous hashes = %q, %q, want h2 to follow h1", first.PreviousHash, second.PreviousHash)
+	}
+
+	if _, err := database.ApplyMigration("create table a (id integer);", Migration{Hash: "h3", FileName: "000003_c.sql"}); err == nil {
+		t.Error("ApplyMigration() of failing SQL succeeded")
+	}
+
+	// A cancelled context stops the lookup
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, err := database.(ContextDB).GetAppliedMigrationsContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetAppliedMigrationsContext() with a cancelled context error = %v, want context.Canceled", err)
+	}
+
+	applied, err := database.GetAppliedMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 || applied[0].Hash != "h1" || applied[1].Hash != "h2" || applied[1].Seq <= applied[0].Seq {
+		t.Fatalf("applied = %+v, want h1 then h2", applied)
+	}
+
+	// An unrecorded hash removes nothing
+	if err := database.RemoveMigrations([]string{"h2", "unknown"}); err == nil {
+		t.Error("RemoveMigrations() of an unrecorded hash succeeded")
+	}
+	if applied, _ := database.GetAppliedMigrations(); len(applied) != 2 {
+		t.Errorf("applied = %+v, want both kept", applied)
+	}
+
+	if err := database.RemoveMigrations([]string{"h2"}); err != nil {
+		t.Fatalf("RemoveMigrations() error = %v", err)
+	}
+	if applied, _ := database.GetAppliedMigrations(); len(applied) != 1 || applied[0].Hash != "h1" {
+		t.Errorf("applied = %+v, want only h1", applied)
+	}
+}
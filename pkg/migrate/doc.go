@@ -0,0 +1,33 @@
+// Package migrate applies, commits and tracks roll-forward SQL migrations.
+//
+// The CLI is built on this package, and services can use it directly to run
+// their migrations at startup. Migrations can come from any fs.FS, including
+// files embedded in the binary:
+//
+//	//go:embed migrations
+//	var embedded embed.FS
+//
+//	func runMigrations(databaseURL string) error {
+//		database, err := db.NewPostgresDB(databaseURL)
+//		if err != nil {
+//			return err
+//		}
+//		defer database.Close()
+//
+//		migrations, err := fs.Sub(embedded, "migrations")
+//		if err != nil {
+//			return err
+//		}
+//
+//		migrator, err := migrate.New(database,
+//			migrate.WithFS(migrations),
+//			migrate.WithLogger(slog.New(slog.NewJSONHandler(os.Stderr, nil))),
+//		)
+//		if err != nil {
+//			return err
+//		}
+//		return migrator.Migrate()
+//	}
+//
+// Commit, Uncommit and Watch work on a migration directory on disk, see WithDir.
+package migrate
@@ -0,0 +1,84 @@
+package migrate
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// WritableFS is an fs.FS that migrations can also be written to.
+// Commit and Uncommit need one; Migrate and Apply only read.
+type WritableFS interface {
+	fs.FS
+
+	// WriteFile writes data to the named file, creating it if necessary
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+
+	// Rename renames a file, replacing the destination if it exists
+	Rename(oldname string, newname string) error
+
+	// Remove removes the named file
+	Remove(name string) error
+}
+
+// DirFS returns a WritableFS for a migration directory on disk
+func DirFS(dir string) WritableFS {
+	return dirFS(dir)
+}
+
+// dirFS is a WritableFS backed by a directory on disk
+type dirFS string
+
+// Open opens the named file for reading
+func (dir dirFS) Open(name string) (fs.File, error) {
+	return os.DirFS(string(dir)).Open(name)
+}
+
+// WriteFile writes data to the named file, creating it if necessary
+func (dir dirFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	path, err := dir.join("write", name)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, perm)
+}
+
+// Rename renames a file, replacing the destination if it exists
+func (dir dirFS) Rename(oldname string, newname string) error {
+	oldpath, err := dir.join("rename", oldname)
+	if err != nil {
+		return err
+	}
+	newpath, err := dir.join("rename", newname)
+	if err != nil {
+		return err
+	}
+	return os.Rename(oldpath, newpath)
+}
+
+// Remove removes the named file
+func (dir dirFS) Remove(name string) error {
+	path, err := dir.join("remove", name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// join returns the path on disk of a file name, rejecting names that escape the directory
+func (dir dirFS) join(op string, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(string(dir), filepath.FromSlash(name)), nil
+}
+
+// writable returns the migration FS for writing, or an error if it is read-only
+func (m *Migrator) writable() (WritableFS, error) {
+	wfs, ok := m.FS.(WritableFS)
+	if !ok {
+		return nil, fmt.Errorf("migrations are read-only; use WithDir or a WritableFS to commit or uncommit")
+	}
+	return wfs, nil
+}
@@ -0,0 +1,93 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/techtonic-org/rf-migrate/pkg/db"
+)
+
+// NotAppliedError is returned by RequireApplied when the required migration
+// is not recorded in the tracking table
+type NotAppliedError struct {
+	Migration string // The migration as it was required
+	Latest    string // File name of the latest applied migration; empty if none
+}
+
+// Error describes the missing migration and how far the database has got
+func (e *NotAppliedError) Error() string {
+	if e.Latest == "" {
+		return fmt.Sprintf("migration %s has not been applied; no migrations are applied", e.Migration)
+	}
+	return fmt.Sprintf("migration %s has not been applied; latest applied migration is %s", e.Migration, e.Latest)
+}
+
+// RequireApplied returns nil if the given migration has been applied to the database,
+// and a *NotAppliedError if it has not. The migration is matched by file name, with or
+// without the .sql extension, or by hash. The database is only read, so applications
+// can call it at startup without permission to change the schema.
+//
+// The lookup runs with ctx, so a deadline also bounds a server that hangs, as
+// long as database implements db.ContextDB, which every DB of package db does.
+// Other implementations are only checked against ctx before the lookup.
+func RequireApplied(ctx context.Context, database db.DB, migration string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return requireApplied(ctx, database, migration)
+}
+
+// requireApplied looks the migration up in the tracking table
+func requireApplied(ctx context.Context, database db.DB, migration string) error {
+	var applied []db.Migration
+	var err error
+	if cdb, ok := database.(db.ContextDB); ok {
+		applied, err = cdb.GetAppliedMigrationsContext(ctx)
+	} else {
+		applied, err = database.GetAppliedMigrations()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get applied migrations: %w", err)
+	}
+
+	for _, m := range applied {
+		if m.FileName == migration || strings.TrimSuffix(m.FileName, ".sql") == migration || m.Hash == migration {
+			return nil
+		}
+	}
+
+	notApplied := &NotAppliedError{Migration: migration}
+	if len(applied) > 0 {
+		notApplied.Latest = applied[len(applied)-1].FileName
+	}
+	return notApplied
+}
+
+// WaitUntilApplied polls the database until the given migration has been applied,
+// for example by a migrate job running next to the application, or until ctx is done.
+// Errors reading the tracking table are retried too, since it may not exist yet on a
+// fresh database; the last error is returned when ctx is done. An interval of zero
+// polls every two seconds. Like RequireApplied, ctx also bounds a lookup in progress.
+func WaitUntilApplied(ctx context.Context, database db.DB, migration string, interval time.Duration) error {
+	if interval <= 0 {
+		interval = dependencyPollInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := requireApplied(ctx, database, migration)
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("gave up waiting for migration %s: %w", migration, err)
+		case <-ticker.C:
+		}
+	}
+}
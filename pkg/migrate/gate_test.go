@@ -0,0 +1,66 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/techtonic-org/rf-migrate/pkg/db"
+)
+
+// hangingDB is a database whose lookups hang until their context is done
+type hangingDB struct {
+	db.DB
+	hang chan struct{} // Closed when the test ends
+}
+
+func (h hangingDB) GetAppliedMigrations() ([]db.Migration, error) {
+	<-h.hang
+	return nil, errors.New("lookup without a context")
+}
+
+func (h hangingDB) GetAppliedMigrationsContext(ctx context.Context) ([]db.Migration, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-h.hang:
+		return nil, errors.New("lookup was not interrupted")
+	}
+}
+
+func TestGateInterruptsLookup(t *testing.T) {
+	tests := []struct {
+		name string
+		gate func(ctx context.Context, database db.DB) error
+	}{
+		{name: "RequireApplied", gate: func(ctx context.Context, database db.DB) error {
+			return RequireApplied(ctx, database, "000001_users")
+		}},
+		{name: "WaitUntilApplied", gate: func(ctx context.Context, database db.DB) error {
+			return WaitUntilApplied(ctx, database, "000001_users", time.Hour)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := hangingDB{hang: make(chan struct{})}
+			defer close(database.hang)
+
+			ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+			defer cancel()
+
+			done := make(chan error, 1)
+			go func() { done <- tt.gate(ctx, database) }()
+
+			select {
+			case err := <-done:
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("error = %v, want the deadline", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("the lookup in progress was not interrupted by ctx")
+			}
+		})
+	}
+}
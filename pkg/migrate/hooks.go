@@ -0,0 +1,43 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// Hooks are shell commands run around applying committed migrations, by Migrate
+// and Commit. They only run when there is a migration to apply.
+type Hooks struct {
+	PreApply  []string // Run before the first migration is applied; a failure applies nothing
+	PostApply []string // Run after the last migration has been applied
+}
+
+// runHooks runs the commands of a hook in order, in the migration directory if
+// there is one, and stops at the first that fails
+func (m *Migrator) runHooks(hook string, commands []string) error {
+	ctx := m.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	for _, command := range commands {
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+		} else {
+			cmd = exec.CommandContext(ctx, "sh", "-c", command)
+		}
+		cmd.Dir = m.MigrationDir
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+
+		m.Logger.Info("Running hook", "hook", hook, "command", command)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s hook %q failed: %w", hook, command, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package migrate
+
+import "log/slog"
+
+// Logger receives structured events from a Migrator. Arguments after the
+// message are alternating keys and values, as in log/slog, so a *slog.Logger
+// satisfies Logger directly.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// NewSlogLogger returns a Logger that writes to a log/slog handler,
+// such as slog.NewJSONHandler for JSON application logs
+func NewSlogLogger(handler slog.Handler) Logger {
+	return slog.New(handler)
+}
@@ -1,57 +1,124 @@
 package migrate
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"os"
+	"io/fs"
+	"log/slog"
+	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/techtonic-org/rf-migrate/pkg/db"
 )
 
-// Migrator handles database migrations
+// currentSQL is the name of the in-progress migration file
+const currentSQL = "current.sql"
+
+// Migrator handles database migrations. Create one with New. A Migrator built
+// as a struct literal, as before New existed, reads its migrations from
+// MigrationDir on disk when FS is nil and logs to slog.Default() when Logger is nil.
 type Migrator struct {
-	DB            db.DB
-	MigrationDir  string
+	DB           db.DB
+	FS           fs.FS  // Migration files; writable if it implements WritableFS
+	MigrationDir string // Directory on disk behind FS, or "" if FS is not a directory
+	Logger       Logger
+	Naming       string // NamingTimestamp (default) or NamingSequential
+	ToolVersion  string // rf-migrate version recorded with each migration
+
+	// Deprecated: set MigrationDir instead. When MigrationDir is empty, the
+	// migrations are read from MigrationsDir, or else from the directory of
+	// CurrentSQL, which must name current.sql.
 	CurrentSQL    string
 	MigrationsDir string
+
+	// Observers are notified of migration lifecycle events
+	Observers []Observer
+
+	// Dependencies looks up requirements on other migration sets; DependencyWait
+	// is how long Migrate waits for them to be applied before failing
+	Dependencies   DependencyChecker
+	DependencyWait time.Duration
+
+	// Context cancels waiting for dependencies and running hooks; nil means context.Background()
+	Context context.Context
+
+	// Placeholders are substituted for ${name} in migration SQL when it is
+	// applied; hashes are computed from the files as written
+	Placeholders map[string]string
+
+	// Hooks run around applying committed migrations
+	Hooks Hooks
+
+	// lastSchema is the primary database schema as it was before the last reapply
+	lastSchema []string
 }
 
-// NewMigrator creates a new migrator
+// NewMigrator creates a new migrator for a migration directory.
+// It is equivalent to New(database, WithDir(migrationDir)).
 func NewMigrator(database db.DB, migrationDir string) (*Migrator, error) {
-	// Ensure migrations table exists
-	if err := database.EnsureMigrationsTable(); err != nil {
-		return nil, err
+	return New(database, WithDir(migrationDir))
+}
+
+// setDefaults fills in what New sets up for a Migrator built as a struct literal
+func (m *Migrator) setDefaults() error {
+	if m.Logger == nil {
+		m.Logger = slog.Default()
+	}
+	if m.FS != nil {
+		return nil
 	}
 
-	return &Migrator{
-		DB:            database,
-		MigrationDir:  migrationDir,
-		CurrentSQL:    filepath.Join(migrationDir, "current.sql"),
-		MigrationsDir: migrationDir,
-	}, nil
+	if m.MigrationDir == "" {
+		m.MigrationDir = m.MigrationsDir
+	}
+	if m.MigrationDir == "" && m.CurrentSQL != "" {
+		m.MigrationDir = filepath.Dir(m.CurrentSQL)
+	}
+	if m.MigrationDir == "" {
+		return errors.New("no migrations: set MigrationDir or FS, or create the migrator with New")
+	}
+	if m.CurrentSQL != "" && filepath.Clean(m.CurrentSQL) != filepath.Join(m.MigrationDir, currentSQL) {
+		return fmt.Errorf("CurrentSQL %s must be current.sql in the migration directory %s", m.CurrentSQL, m.MigrationDir)
+	}
+
+	m.FS = DirFS(m.MigrationDir)
+	return nil
 }
 
 // Apply applies the current SQL migration file
 func (m *Migrator) Apply() error {
-	content, err := os.ReadFile(m.CurrentSQL)
+	results, err := m.ApplyTargets([]Target{{Name: "database", DB: m.DB}})
 	if err != nil {
-		return fmt.Errorf("failed to read current.sql: %w", err)
-	}
-
-	if len(content) == 0 {
-		return nil // Nothing to apply
+		return err
 	}
-
-	return m.DB.Execute(string(content))
+	return results[0].Err
 }
 
 // Watch watches the current SQL file and reapplies it on changes
 func (m *Migrator) Watch() error {
+	return m.WatchTargets(context.Background(), []Target{{Name: "database", DB: m.DB}}, nil)
+}
+
+// WatchTargets watches the current SQL file and reapplies it to all targets on changes.
+// Functions received on actions run inside the watch loop, so they never overlap a reapply.
+// Watching stops without error when ctx is cancelled.
+func (m *Migrator) WatchTargets(ctx context.Context, targets []Target, actions <-chan func()) error {
+	if err := m.setDefaults(); err != nil {
+		return err
+	}
+
+	if m.MigrationDir == "" {
+		return errors.New("watching requires a migration directory on disk (WithDir)")
+	}
+
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return fmt.Errorf("failed to create watcher: %w", err)
@@ -59,97 +126,160 @@ func (m *Migrator) Watch() error {
 	defer watcher.Close()
 
 	// Apply initially
-	if err := m.Apply(); err != nil {
+	if err := m.Reapply(targets); err != nil {
 		return err
 	}
 
 	// Watch for changes
-	if err := watcher.Add(m.CurrentSQL); err != nil {
+	if err := watcher.Add(filepath.Join(m.MigrationDir, currentSQL)); err != nil {
 		return fmt.Errorf("failed to watch current.sql: %w", err)
 	}
 
-	fmt.Println("Watching for changes to current.sql...")
+	m.Logger.Info("Watching for changes", "file", filepath.Join(m.MigrationDir, currentSQL))
 
 	for {
 		select {
+		case <-ctx.Done():
+			return nil
+		case action := <-actions:
+			action()
 		case event, ok := <-watcher.Events:
 			if !ok {
 				return nil
 			}
 			if event.Op&fsnotify.Write == fsnotify.Write {
-				fmt.Println("current.sql modified, reapplying...")
-				if err := m.Apply(); err != nil {
-					fmt.Printf("Error reapplying: %v\n", err)
-				} else {
-					fmt.Println("Applied successfully")
+				m.Logger.Info("current.sql modified, reapplying")
+				if err := m.Reapply(targets); err != nil {
+					m.Logger.Error("Failed to reapply current.sql", "error", err)
 				}
 			}
 		case err, ok := <-watcher.Errors:
 			if !ok {
 				return nil
 			}
-			fmt.Printf("Watcher error: %v\n", err)
+			m.Logger.Error("Watcher error", "error", err)
+		}
+	}
+}
+
+// Reapply applies the current SQL file to all targets and logs a per-target summary.
+// The schema of the first target is recorded beforehand so SchemaDiff can report what changed.
+func (m *Migrator) Reapply(targets []Target) error {
+	if err := m.setDefaults(); err != nil {
+		return err
+	}
+
+	if len(targets) > 0 {
+		schema, err := targets[0].DB.DescribeSchema()
+		if err != nil {
+			return err
+		}
+		if schema == nil {
+			schema = []string{} // An empty schema is still a snapshot
 		}
+		m.lastSchema = schema
+	}
+
+	results, err := m.ApplyTargets(targets)
+	if err != nil {
+		return err
 	}
+	m.LogResults(results)
+	return FailedResults(results)
 }
 
 // Commit commits the current SQL file to a new migration
 func (m *Migrator) Commit(name string) error {
+	if err := m.setDefaults(); err != nil {
+		return err
+	}
+
+	wfs, err := m.writable()
+	if err != nil {
+		return err
+	}
+
 	// Read current content
-	content, err := os.ReadFile(m.CurrentSQL)
+	content, err := m.readCurrent()
 	if err != nil {
-		return fmt.Errorf("failed to read current.sql: %w", err)
+		return err
 	}
 
 	if len(content) == 0 {
 		return fmt.Errorf("nothing to commit: current.sql is empty")
 	}
 
-	// Generate timestamp and filename
-	timestamp := time.Now().UTC().Format("20060102150405")
-	sanitizedName := strings.ReplaceAll(name, " ", "_")
-	fileName := fmt.Sprintf("%s_%s.sql", timestamp, sanitizedName)
-	fullPath := filepath.Join(m.MigrationsDir, fileName)
+	// Generate filename
+	files, err := getFiles(m.FS)
+	if err != nil {
+		return fmt.Errorf("failed to read migrations directory: %w", err)
+	}
+	fileName, err := m.nextFileName(name, files)
+	if err != nil {
+		return err
+	}
 
 	// Calculate hash
 	hash := computeHash(content)
 
-	// Get previous hash
-	var previousHash string
-	migrations, err := m.DB.GetAppliedMigrations()
+	// Refuse to overwrite an existing migration
+	if _, err := fs.Stat(m.FS, fileName); err == nil {
+		return fmt.Errorf("migration file %s already exists", fileName)
+	}
+
+	record, err := m.record(fileName, content, hash, m.gitCommit())
 	if err != nil {
-		return fmt.Errorf("failed to get applied migrations: %w", err)
+		return err
 	}
-	if len(migrations) > 0 {
-		previousHash = migrations[len(migrations)-1].Hash
+
+	if err := m.runHooks("preApply", m.Hooks.PreApply); err != nil {
+		return err
 	}
 
-	// Write migration file
-	if err := os.WriteFile(fullPath, content, 0644); err != nil {
+	// Stage the migration file next to its final location so the rename is atomic
+	tempName := "." + fileName + ".tmp"
+	if err := wfs.WriteFile(tempName, content, 0644); err != nil {
 		return fmt.Errorf("failed to write migration file: %w", err)
 	}
 
-	// Apply the migration and record it
-	if err := m.DB.Execute(string(content)); err != nil {
+	// Apply the migration and record it, in one transaction unless its headers opt out
+	m.notify(func(o Observer) { o.OnMigrationStart(fileName) })
+	applied, err := m.DB.ApplyMigration(m.expand(content), record)
+	if err != nil {
+		wfs.Remove(tempName) //nolint:errcheck
+		m.notify(func(o Observer) { o.OnMigrationFailed(fileName, err) })
 		return fmt.Errorf("failed to apply migration: %w", err)
 	}
 
-	// Record the migration
-	if err := m.DB.ApplyMigration(fileName, hash, previousHash); err != nil {
-		return fmt.Errorf("failed to record migration: %w", err)
+	// Move the migration file into place now that the database has committed
+	if err := wfs.Rename(tempName, fileName); err != nil {
+		err = fmt.Errorf("migration was recorded but moving %s to %s failed: %w", tempName, fileName, err)
+		m.notify(func(o Observer) { o.OnMigrationFailed(fileName, err) })
+		return err
 	}
 
 	// Clear current.sql
-	if err := os.WriteFile(m.CurrentSQL, []byte{}, 0644); err != nil {
-		return fmt.Errorf("failed to clear current.sql: %w", err)
+	if err := wfs.WriteFile(currentSQL, []byte{}, 0644); err != nil {
+		err = fmt.Errorf("migration was recorded but clearing current.sql failed: %w", err)
+		m.notify(func(o Observer) { o.OnMigrationFailed(fileName, err) })
+		return err
 	}
 
-	fmt.Printf("Committed migration: %s\n", fileName)
+	m.Logger.Info("Committed migration", migrationAttrs(applied)...)
+	m.notify(func(o Observer) { o.OnCommit(applied) })
+
+	if err := m.runHooks("postApply", m.Hooks.PostApply); err != nil {
+		return fmt.Errorf("migration was committed but %w", err)
+	}
 	return nil
 }
 
 // Migrate applies all unapplied migrations
 func (m *Migrator) Migrate() error {
+	if err := m.setDefaults(); err != nil {
+		return err
+	}
+
 	// Get applied migrations
 	appliedMigrations, err := m.DB.GetAppliedMigrations()
 	if err != nil {
@@ -157,7 +287,7 @@ func (m *Migrator) Migrate() error {
 	}
 
 	// Get all migration files
-	files, err := getFiles(m.MigrationsDir)
+	files, err := getFiles(m.FS)
 	if err != nil {
 		return fmt.Errorf("failed to read migrations directory: %w", err)
 	}
@@ -168,96 +298,233 @@ func (m *Migrator) Migrate() error {
 		appliedFiles[migration.FileName] = true
 	}
 
-	// Find and apply unapplied migrations
-	appliedCount := 0
-	var lastHash string
-	if len(appliedMigrations) > 0 {
-		lastHash = appliedMigrations[len(appliedMigrations)-1].Hash
+	// Hooks only run when there is something to apply
+	pending := 0
+	for _, file := range files {
+		if !appliedFiles[file] {
+			pending++
+		}
+	}
+	if pending > 0 {
+		if err := m.runHooks("preApply", m.Hooks.PreApply); err != nil {
+			return err
+		}
 	}
 
+	// Find and apply unapplied migrations; the git commit is looked up once, when needed
+	gitCommit := sync.OnceValue(m.gitCommit)
+	appliedCount := 0
 	for _, file := range files {
 		if !appliedFiles[file] {
 			// Read migration file
-			fullPath := filepath.Join(m.MigrationsDir, file)
-			content, err := os.ReadFile(fullPath)
+			content, err := fs.ReadFile(m.FS, file)
 			if err != nil {
 				return fmt.Errorf("failed to read migration file %s: %w", file, err)
 			}
 
-			// Apply migration
-			if err := m.DB.Execute(string(content)); err != nil {
-				return fmt.Errorf("failed to apply migration %s: %w", file, err)
+			// Check declared requirements, which may wait for other sets
+			m.notify(func(o Observer) { o.OnMigrationStart(file) })
+			if err := m.checkRequirements(file, content, appliedFiles); err != nil {
+				m.notify(func(o Observer) { o.OnMigrationFailed(file, err) })
+				return err
 			}
 
 			// Calculate hash
 			hash := computeHash(content)
 
-			// Record migration
-			if err := m.DB.ApplyMigration(file, hash, lastHash); err != nil {
-				return fmt.Errorf("failed to record migration %s: %w", file, err)
+			record, err := m.record(file, content, hash, gitCommit())
+			if err != nil {
+				m.notify(func(o Observer) { o.OnMigrationFailed(file, err) })
+				return err
+			}
+
+			// Apply and record migration, in one transaction unless its headers opt out
+			applied, err := m.DB.ApplyMigration(m.expand(content), record)
+			if err != nil {
+				m.Logger.Error("Failed to apply migration", "file", file, "hash", hash, "error", err)
+				m.notify(func(o Observer) { o.OnMigrationFailed(file, err) })
+				return fmt.Errorf("failed to apply migration %s: %w", file, err)
 			}
 
-			lastHash = hash
+			appliedFiles[file] = true
 			appliedCount++
-			fmt.Printf("Applied migration: %s\n", file)
+			m.Logger.Info("Applied migration", migrationAttrs(applied)...)
+			m.notify(func(o Observer) { o.OnMigrationApplied(applied) })
 		}
 	}
 
-	fmt.Printf("Applied %d migrations\n", appliedCount)
+	m.Logger.Info("Migrations applied", "count", appliedCount)
+
+	if appliedCount > 0 {
+		if err := m.runHooks("postApply", m.Hooks.PostApply); err != nil {
+			return fmt.Errorf("migrations were applied but %w", err)
+		}
+	}
 	return nil
 }
 
-// Uncommit removes the last migration
-func (m *Migrator) Uncommit() error {
-	// Remove last migration
-	migration, err := m.DB.RemoveLastMigration()
+// Uncommit folds the last steps migrations back into current.sql.
+// Each migration must be the newest file on disk and unchanged since it was applied.
+// Unless force is set, it refuses to run when current.sql has content.
+func (m *Migrator) Uncommit(steps int, force bool) error {
+	if err := m.setDefaults(); err != nil {
+		return err
+	}
+
+	if steps < 1 {
+		return fmt.Errorf("steps must be at least 1, got %d", steps)
+	}
+
+	wfs, err := m.writable()
 	if err != nil {
-		return fmt.Errorf("failed to remove last migration: %w", err)
+		return err
+	}
+
+	// Protect work in progress
+	currentContent, err := m.readCurrent()
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(currentContent)) > 0 && !force {
+		return fmt.Errorf("current.sql has uncommitted content; commit it, clear it or use --force to keep it after the uncommitted migrations")
 	}
 
-	// Read migration file
-	migrationPath := filepath.Join(m.MigrationsDir, migration.FileName)
-	content, err := os.ReadFile(migrationPath)
+	// Find the migrations to uncommit
+	applied, err := m.DB.GetAppliedMigrations()
 	if err != nil {
-		return fmt.Errorf("failed to read migration file: %w", err)
+		return fmt.Errorf("failed to get applied migrations: %w", err)
+	}
+	if steps > len(applied) {
+		return fmt.Errorf("cannot uncommit %d migrations: only %d applied", steps, len(applied))
 	}
+	migrations := applied[len(applied)-steps:]
 
-	// Append to current.sql
-	currentContent, err := os.ReadFile(m.CurrentSQL)
+	// They must be the newest files on disk, in the same order
+	files, err := getFiles(m.FS)
 	if err != nil {
-		return fmt.Errorf("failed to read current.sql: %w", err)
+		return fmt.Errorf("failed to read migrations directory: %w", err)
+	}
+	if len(files) < steps {
+		return fmt.Errorf("cannot uncommit %d migrations: only %d migration files on disk", steps, len(files))
+	}
+	newestFiles := files[len(files)-steps:]
+	for i, migration := range migrations {
+		if newestFiles[i] != migration.FileName {
+			return fmt.Errorf("migration %s is not the newest file on disk (found %s); refusing to uncommit", migration.FileName, newestFiles[i])
+		}
 	}
 
-	combinedContent := append(content, currentContent...)
-	if err := os.WriteFile(m.CurrentSQL, combinedContent, 0644); err != nil {
+	// Check integrity and fold the migrations together, oldest first
+	var combinedContent []byte
+	hashes := make([]string, len(migrations))
+	for i, migration := range migrations {
+		content, err := fs.ReadFile(m.FS, migration.FileName)
+		if err != nil {
+			return fmt.Errorf("failed to read migration file: %w", err)
+		}
+		if hash := computeHash(content); hash != migration.Hash {
+			return fmt.Errorf("migration file %s was modified after it was applied (hash %s, recorded %s)", migration.FileName, hash, migration.Hash)
+		}
+
+		combinedContent = append(combinedContent, content...)
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			combinedContent = append(combinedContent, '\n')
+		}
+		hashes[i] = migration.Hash
+	}
+	combinedContent = append(combinedContent, currentContent...)
+
+	// Remove the migration records
+	if err := m.DB.RemoveMigrations(hashes); err != nil {
+		return fmt.Errorf("failed to remove migrations: %w", err)
+	}
+
+	// Put the content back into current.sql
+	if err := wfs.WriteFile(currentSQL, combinedContent, 0644); err != nil {
 		return fmt.Errorf("failed to update current.sql: %w", err)
 	}
 
-	// Delete migration file
-	if err := os.Remove(migrationPath); err != nil {
-		return fmt.Errorf("failed to delete migration file: %w", err)
+	// Delete migration files
+	for _, migration := range migrations {
+		if err := wfs.Remove(migration.FileName); err != nil {
+			return fmt.Errorf("failed to delete migration file: %w", err)
+		}
+		m.Logger.Info("Uncommitted migration", "file", migration.FileName, "hash", migration.Hash)
 	}
 
-	fmt.Printf("Uncommitted migration: %s\n", migration.FileName)
 	return nil
 }
 
+// migrationAttrs returns the log attributes describing an applied migration
+func migrationAttrs(migration db.Migration) []any {
+	return []any{
+		"file", migration.FileName,
+		"hash", migration.Hash,
+		"duration", migration.Duration,
+		"rows_affected", migration.RowsAffected,
+	}
+}
+
+// record builds the migration record for a migration about to be applied,
+// taking how to apply it from the headers of its content
+func (m *Migrator) record(fileName string, content []byte, hash string, gitCommit string) (db.Migration, error) {
+	noTransaction, err := parseNoTransaction(content)
+	if err != nil {
+		return db.Migration{}, fmt.Errorf("migration %s: %w", fileName, err)
+	}
+
+	return db.Migration{
+		Hash:          hash,
+		FileName:      fileName,
+		ToolVersion:   m.ToolVersion,
+		GitCommit:     gitCommit,
+		NoTransaction: noTransaction,
+	}, nil
+}
+
+// gitCommit returns the commit checked out in the repository containing the
+// migration directory, or "" if there is none or git is not installed
+func (m *Migrator) gitCommit() string {
+	if m.MigrationDir == "" {
+		return ""
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		m.Logger.Debug("git is not installed; recording migrations without a git commit")
+		return ""
+	}
+
+	out, err := exec.Command("git", "-C", m.MigrationDir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// readCurrent reads the contents of current.sql
+func (m *Migrator) readCurrent() ([]byte, error) {
+	content, err := fs.ReadFile(m.FS, currentSQL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read current.sql: %w", err)
+	}
+	return content, nil
+}
+
 // computeHash calculates a SHA-256 hash of the content
 func computeHash(content []byte) string {
 	hash := sha256.Sum256(content)
 	return hex.EncodeToString(hash[:])
 }
 
-// getFiles returns all .sql files in the directory, sorted by name
-func getFiles(dir string) ([]string, error) {
-	entries, err := os.ReadDir(dir)
+// getFiles returns all .sql migration files at the root of fsys, sorted by name
+func getFiles(fsys fs.FS) ([]string, error) {
+	entries, err := fs.ReadDir(fsys, ".")
 	if err != nil {
 		return nil, err
 	}
 
 	var files []string
 	for _, entry := range entries {
-		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".sql") && entry.Name() != "current.sql" {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".sql") && entry.Name() != currentSQL {
 			files = append(files, entry.Name())
 		}
 	}
@@ -0,0 +1,542 @@
+//go:build cgo
+
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/techtonic-org/rf-migrate/pkg/db"
+)
+
+// newSQLiteMigrator returns a migrator with sequential naming for a fresh SQLite
+// database and migration directory
+func newSQLiteMigrator(t *testing.T, opts ...Option) *Migrator {
+	t.Helper()
+
+	dir := t.TempDir()
+	migrationDir := filepath.Join(dir, "migrations")
+	if err := os.MkdirAll(migrationDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, migrationDir, currentSQL, "")
+
+	database, err := db.Open("sqlite://"+filepath.Join(dir, "test.db"), db.DefaultTrackingTable)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	opts = append([]Option{WithDir(migrationDir), WithNaming(NamingSequential), WithLogger(slog.New(slog.DiscardHandler))}, opts...)
+	m, err := New(database, opts...)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return m
+}
+
+// writeFile writes a file in dir
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readFile reads a file in dir
+func readFile(t *testing.T, dir, name string) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+// appliedFiles returns the file names of the applied migrations, in order
+func appliedFiles(t *testing.T, m *Migrator) []string {
+	t.Helper()
+	applied, err := m.DB.GetAppliedMigrations()
+	if err != nil {
+		t.Fatalf("GetAppliedMigrations() error = %v", err)
+	}
+	var files []string
+	for _, migration := range applied {
+		files = append(files, migration.FileName)
+	}
+	return files
+}
+
+// diskFiles returns the committed migration files on disk
+func diskFiles(t *testing.T, m *Migrator) []string {
+	t.Helper()
+	files, err := getFiles(m.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestMigrateSQLite(t *testing.T) {
+	m := newSQLiteMigrator(t)
+	writeFile(t, m.MigrationDir, "000001_users.sql", "create table if not exists users (id integer primary key);\n")
+	writeFile(t, m.MigrationDir, "000002_orders.sql", "create table if not exists orders (id integer primary key, user_id integer);\n")
+
+	if err := m.Migrate(); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	want := []string{"000001_users.sql", "000002_orders.sql"}
+	if got := appliedFiles(t, m); !reflect.DeepEqual(got, want) {
+		t.Fatalf("applied = %v, want %v", got, want)
+	}
+
+	// Records are linked in order
+	applied, _ := m.DB.GetAppliedMigrations()
+	if applied[0].PreviousHash != "" || applied[1].PreviousHash != applied[0].Hash {
+		t.Errorf("previous hashes = %q, %q, want chain from %q", applied[0].PreviousHash, applied[1].PreviousHash, applied[0].Hash)
+	}
+
+	// Applied migrations are not applied again
+	if err := m.Migrate(); err != nil {
+		t.Fatalf("second Migrate() error = %v", err)
+	}
+	if got := appliedFiles(t, m); !reflect.DeepEqual(got, want) {
+		t.Fatalf("applied after second Migrate = %v, want %v", got, want)
+	}
+}
+
+func TestMigrateSQLiteNoTransaction(t *testing.T) {
+	m := newSQLiteMigrator(t)
+	writeFile(t, m.MigrationDir, "000001_users.sql", "create table users (id integer primary key);\n")
+	// vacuum fails inside a transaction
+	writeFile(t, m.MigrationDir, "000002_vacuum.sql", "--! no-transaction\nvacuum;\n")
+
+	if err := m.Migrate(); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	want := []string{"000001_users.sql", "000002_vacuum.sql"}
+	if got := appliedFiles(t, m); !reflect.DeepEqual(got, want) {
+		t.Fatalf("applied = %v, want %v", got, want)
+	}
+
+	// Without the header the same statement fails and is not recorded
+	writeFile(t, m.MigrationDir, "000003_vacuum_again.sql", "vacuum;\n")
+	if err := m.Migrate(); err == nil {
+		t.Fatal("Migrate() succeeded, want vacuum to fail inside a transaction")
+	}
+	if got := appliedFiles(t, m); !reflect.DeepEqual(got, want) {
+		t.Fatalf("applied after failure = %v, want %v", got, want)
+	}
+}
+
+func TestMigrateSQLitePlaceholdersAndHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh syntax")
+	}
+
+	log := filepath.Join(t.TempDir(), "hooks.log")
+	m := newSQLiteMigrator(t,
+		WithPlaceholders(map[string]string{"table": "accounts"}),
+		WithHooks(Hooks{
+			PreApply:  []string{"echo pre >> " + log},
+			PostApply: []string{"echo post >> " + log},
+		}),
+	)
+	content := "create table ${table} (id integer primary key);\n"
+	writeFile(t, m.MigrationDir, "000001_accounts.sql", content)
+
+	if err := m.Migrate(); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if err := m.DB.Execute("insert into accounts (id) values (1);"); err != nil {
+		t.Errorf("placeholder was not substituted: %v", err)
+	}
+
+	// The hash is that of the file as written
+	applied, _ := m.DB.GetAppliedMigrations()
+	if applied[0].Hash != computeHash([]byte(content)) {
+		t.Errorf("hash = %s, want the hash of the file", applied[0].Hash)
+	}
+
+	// Hooks do not run when there is nothing to apply
+	if err := m.Migrate(); err != nil {
+		t.Fatalf("second Migrate() error = %v", err)
+	}
+	if got := readFile(t, filepath.Dir(log), "hooks.log"); got != "pre\npost\n" {
+		t.Errorf("hooks ran %q, want pre and post once", got)
+	}
+
+	// A failing pre-apply hook applies nothing
+	m.Hooks.PreApply = []string{"exit 3"}
+	writeFile(t, m.MigrationDir, "000002_orders.sql", "create table orders (id integer primary key);\n")
+	if err := m.Migrate(); err == nil || !strings.Contains(err.Error(), "preApply hook") {
+		t.Fatalf("Migrate() error = %v, want the pre-apply hook to fail", err)
+	}
+	if got, want := appliedFiles(t, m), []string{"000001_accounts.sql"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("applied = %v, want %v", got, want)
+	}
+}
+
+func TestMigrateSQLiteFailureLeavesNoRecord(t *testing.T) {
+	m := newSQLiteMigrator(t)
+	writeFile(t, m.MigrationDir, "000001_broken.sql", "create table partial (id integer);\nselect * from missing_table;\n")
+
+	if err := m.Migrate(); err == nil {
+		t.Fatal("Migrate() succeeded, want an error")
+	}
+	if got := appliedFiles(t, m); len(got) != 0 {
+		t.Errorf("applied = %v, want none", got)
+	}
+
+	schema, err := m.DB.DescribeSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range schema {
+		if strings.Contains(line, "partial") {
+			t.Errorf("schema contains %q, want the failed migration rolled back", line)
+		}
+	}
+}
+
+// recorder is an observer that records the hooks called
+type recorder struct {
+	NopObserver
+	events []string
+}
+
+func (r *recorder) OnMigrationStart(file string) { r.events = append(r.events, "start "+file) }
+func (r *recorder) OnMigrationFailed(file string, err error) {
+	r.events = append(r.events, "failed "+file)
+}
+func (r *recorder) OnCommit(migration db.Migration) {
+	r.events = append(r.events, "commit "+migration.FileName)
+}
+func (r *recorder) OnMigrationApplied(migration db.Migration) {
+	r.events = append(r.events, "applied "+migration.FileName)
+}
+
+func TestMigrateSQLiteObserverOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []string
+	}{
+		{
+			name:  "applied",
+			files: map[string]string{"000001_a.sql": "create table a (id integer);\n", "000002_b.sql": "create table b (id integer);\n"},
+			want:  []string{"start 000001_a.sql", "applied 000001_a.sql", "start 000002_b.sql", "applied 000002_b.sql"},
+		},
+		{
+			name:  "failed",
+			files: map[string]string{"000001_a.sql": "create table a (id integer);\n", "000002_b.sql": "select * from missing;\n"},
+			want:  []string{"start 000001_a.sql", "applied 000001_a.sql", "start 000002_b.sql", "failed 000002_b.sql"},
+		},
+		{
+			name:  "requirement not applied",
+			files: map[string]string{"000001_a.sql": "--! requires: 000000_missing.sql\ncreate table a (id integer);\n"},
+			want:  []string{"start 000001_a.sql", "failed 000001_a.sql"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			observer := &recorder{}
+			m := newSQLiteMigrator(t, WithObserver(observer))
+			for name, content := range tt.files {
+				writeFile(t, m.MigrationDir, name, content)
+			}
+
+			m.Migrate() //nolint:errcheck // Failures are checked through the events
+			if !reflect.DeepEqual(observer.events, tt.want) {
+				t.Errorf("events = %v, want %v", observer.events, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommitUncommitSQLite(t *testing.T) {
+	observer := &recorder{}
+	m := newSQLiteMigrator(t, WithObserver(observer))
+
+	first := "create table if not exists users (id integer primary key);\n"
+	second := "create table if not exists orders (id integer primary key);\n"
+
+	writeFile(t, m.MigrationDir, currentSQL, first)
+	if err := m.Commit("add users"); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	writeFile(t, m.MigrationDir, currentSQL, second)
+	if err := m.Commit("add orders"); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+
+	want := []string{"000001_add_users.sql", "000002_add_orders.sql"}
+	if got := appliedFiles(t, m); !reflect.DeepEqual(got, want) {
+		t.Fatalf("applied = %v, want %v", got, want)
+	}
+	if got := diskFiles(t, m); !reflect.DeepEqual(got, want) {
+		t.Fatalf("files = %v, want %v", got, want)
+	}
+	if got := readFile(t, m.MigrationDir, currentSQL); got != "" {
+		t.Errorf("current.sql = %q, want it cleared", got)
+	}
+	if got := readFile(t, m.MigrationDir, want[0]); got != first {
+		t.Errorf("%s = %q, want %q", want[0], got, first)
+	}
+
+	wantEvents := []string{
+		"start 000001_add_users.sql", "commit 000001_add_users.sql",
+		"start 000002_add_orders.sql", "commit 000002_add_orders.sql",
+	}
+	if !reflect.DeepEqual(observer.events, wantEvents) {
+		t.Errorf("events = %v, want %v", observer.events, wantEvents)
+	}
+
+	// Fold both migrations back into current.sql, oldest first
+	if err := m.Uncommit(2, false); err != nil {
+		t.Fatalf("Uncommit() error = %v", err)
+	}
+	if got := appliedFiles(t, m); len(got) != 0 {
+		t.Errorf("applied after Uncommit = %v, want none", got)
+	}
+	if got := diskFiles(t, m); len(got) != 0 {
+		t.Errorf("files after Uncommit = %v, want none", got)
+	}
+	if got := readFile(t, m.MigrationDir, currentSQL); got != first+second {
+		t.Errorf("current.sql = %q, want %q", got, first+second)
+	}
+
+	// The folded migration commits again as one
+	if err := m.Commit("add users and orders"); err != nil {
+		t.Fatalf("Commit() after Uncommit error = %v", err)
+	}
+	if got := appliedFiles(t, m); !reflect.DeepEqual(got, []string{"000001_add_users_and_orders.sql"}) {
+		t.Errorf("applied = %v, want the folded migration", got)
+	}
+}
+
+func TestCommitSQLiteFailure(t *testing.T) {
+	observer := &recorder{}
+	m := newSQLiteMigrator(t, WithObserver(observer))
+
+	broken := "select * from missing_table;\n"
+	writeFile(t, m.MigrationDir, currentSQL, broken)
+	if err := m.Commit("broken"); err == nil {
+		t.Fatal("Commit() succeeded, want an error")
+	}
+
+	if got := diskFiles(t, m); len(got) != 0 {
+		t.Errorf("files = %v, want none", got)
+	}
+	if got := readFile(t, m.MigrationDir, currentSQL); got != broken {
+		t.Errorf("current.sql = %q, want it kept", got)
+	}
+	if entries, _ := os.ReadDir(m.MigrationDir); len(entries) != 1 {
+		t.Errorf("migration directory has %d entries, want only current.sql", len(entries))
+	}
+
+	wantEvents := []string{"start 000001_broken.sql", "failed 000001_broken.sql"}
+	if !reflect.DeepEqual(observer.events, wantEvents) {
+		t.Errorf("events = %v, want %v", observer.events, wantEvents)
+	}
+}
+
+func TestUncommitSQLiteIntegrity(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   int
+		force   bool
+		prepare func(t *testing.T, m *Migrator)
+		wantErr string
+	}{
+		{name: "no steps", steps: 0, wantErr: "steps must be at least 1"},
+		{name: "more steps than applied", steps: 3, wantErr: "only 2 applied"},
+		{
+			name:  "work in progress",
+			steps: 1,
+			prepare: func(t *testing.T, m *Migrator) {
+				writeFile(t, m.MigrationDir, currentSQL, "select 1;\n")
+			},
+			wantErr: "current.sql has uncommitted content",
+		},
+		{
+			name:  "modified migration",
+			steps: 1,
+			prepare: func(t *testing.T, m *Migrator) {
+				writeFile(t, m.MigrationDir, "000002_b.sql", "select 2;\n")
+			},
+			wantErr: "was modified after it was applied",
+		},
+		{
+			name:  "newer file on disk",
+			steps: 1,
+			prepare: func(t *testing.T, m *Migrator) {
+				writeFile(t, m.MigrationDir, "000003_c.sql", "select 3;\n")
+			},
+			wantErr: "is not the newest file on disk",
+		},
+		{
+			name:  "forced with work in progress",
+			steps: 1,
+			force: true,
+			prepare: func(t *testing.T, m *Migrator) {
+				writeFile(t, m.MigrationDir, currentSQL, "select 1;\n")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newSQLiteMigrator(t)
+			writeFile(t, m.MigrationDir, "000001_a.sql", "create table if not exists a (id integer);\n")
+			writeFile(t, m.MigrationDir, "000002_b.sql", "create table if not exists b (id integer);\n")
+			if err := m.Migrate(); err != nil {
+				t.Fatalf("Migrate() error = %v", err)
+			}
+			if tt.prepare != nil {
+				tt.prepare(t, m)
+			}
+
+			err := m.Uncommit(tt.steps, tt.force)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Uncommit() error = %v, want %q", err, tt.wantErr)
+				}
+				// Nothing is changed when Uncommit refuses
+				if got := appliedFiles(t, m); len(got) != 2 {
+					t.Errorf("applied = %v, want both migrations kept", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Uncommit() error = %v", err)
+			}
+			if got := readFile(t, m.MigrationDir, currentSQL); got != "create table if not exists b (id integer);\nselect 1;\n" {
+				t.Errorf("current.sql = %q, want the migration followed by the work in progress", got)
+			}
+		})
+	}
+}
+
+func TestRequireAppliedSQLite(t *testing.T) {
+	m := newSQLiteMigrator(t)
+	writeFile(t, m.MigrationDir, "000001_users.sql", "create table if not exists users (id integer);\n")
+	if err := m.Migrate(); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	applied, _ := m.DB.GetAppliedMigrations()
+
+	tests := []struct {
+		migration  string
+		notApplied bool
+	}{
+		{migration: "000001_users.sql"},
+		{migration: "000001_users"},
+		{migration: applied[0].Hash},
+		{migration: "000002_orders", notApplied: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.migration, func(t *testing.T) {
+			err := RequireApplied(t.Context(), m.DB, tt.migration)
+			var notApplied *NotAppliedError
+			if got := errors.As(err, &notApplied); got != tt.notApplied {
+				t.Fatalf("RequireApplied(%q) error = %v, want not applied: %v", tt.migration, err, tt.notApplied)
+			}
+			if tt.notApplied && notApplied.Latest != "000001_users.sql" {
+				t.Errorf("Latest = %q, want 000001_users.sql", notApplied.Latest)
+			}
+			if !tt.notApplied && err != nil {
+				t.Errorf("RequireApplied(%q) error = %v", tt.migration, err)
+			}
+		})
+	}
+}
+
+// countingLogger counts the messages logged
+type countingLogger struct {
+	messages map[string]int
+}
+
+func (l *countingLogger) log(msg string)                { l.messages[msg]++ }
+func (l *countingLogger) Debug(msg string, args ...any) { l.log(msg) }
+func (l *countingLogger) Info(msg string, args ...any)  { l.log(msg) }
+func (l *countingLogger) Warn(msg string, args ...any)  { l.log(msg) }
+func (l *countingLogger) Error(msg string, args ...any) { l.log(msg) }
+
+func TestMigrateSQLiteWithoutGit(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+
+	logger := &countingLogger{messages: make(map[string]int)}
+	m := newSQLiteMigrator(t, WithLogger(logger))
+	for i, file := range []string{"000001_a.sql", "000002_b.sql", "000003_c.sql"} {
+		writeFile(t, m.MigrationDir, file, fmt.Sprintf("select %d;\n", i))
+	}
+
+	if err := m.Migrate(); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if got := logger.messages["git is not installed; recording migrations without a git commit"]; got != 1 {
+		t.Errorf("missing git logged %d times, want once", got)
+	}
+	for _, migration := range mustApplied(t, m) {
+		if migration.GitCommit != "" {
+			t.Errorf("%s recorded git commit %q, want none", migration.FileName, migration.GitCommit)
+		}
+	}
+}
+
+// mustApplied returns the applied migrations
+func mustApplied(t *testing.T, m *Migrator) []db.Migration {
+	t.Helper()
+	applied, err := m.DB.GetAppliedMigrations()
+	if err != nil {
+		t.Fatalf("GetAppliedMigrations() error = %v", err)
+	}
+	return applied
+}
+
+func TestStructLiteralMigratorSQLite(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := os.Mkdir("migrations", 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, "migrations", currentSQL, "create table if not exists users (id integer);\n")
+	writeFile(t, "migrations", "000001_accounts.sql", "create table if not exists accounts (id integer);\n")
+
+	database, err := db.Open("sqlite://test.db", db.DefaultTrackingTable)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+	if err := database.EnsureMigrationsTable(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Built the way callers did before New existed
+	m := &Migrator{DB: database, MigrationDir: "migrations", Naming: NamingSequential}
+
+	if err := m.Migrate(); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if err := m.Commit("add users"); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+
+	want := []string{"000001_accounts.sql", "000002_add_users.sql"}
+	if got := appliedFiles(t, m); !reflect.DeepEqual(got, want) {
+		t.Errorf("applied = %v, want %v", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "migrations", want[1])); err != nil {
+		t.Errorf("committed file is not in the relative migration directory: %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package migrate
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStructLiteralMigratorDeprecatedFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		m       Migrator
+		wantDir string
+		wantErr string
+	}{
+		{name: "migration dir", m: Migrator{MigrationDir: "db"}, wantDir: "db"},
+		{name: "migrations dir", m: Migrator{MigrationsDir: "db", CurrentSQL: "db/current.sql"}, wantDir: "db"},
+		{name: "current.sql only", m: Migrator{CurrentSQL: "db/current.sql"}, wantDir: "db"},
+		{name: "current.sql elsewhere", m: Migrator{MigrationsDir: "db", CurrentSQL: "wip.sql"}, wantErr: "must be current.sql"},
+		{name: "nothing", m: Migrator{}, wantErr: "no migrations"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.m.setDefaults()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("setDefaults() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("setDefaults() error = %v", err)
+			}
+			if tt.m.MigrationDir != tt.wantDir || tt.m.FS == nil || tt.m.Logger == nil {
+				t.Errorf("MigrationDir = %q, FS = %v, Logger = %v", tt.m.MigrationDir, tt.m.FS, tt.m.Logger)
+			}
+		})
+	}
+}
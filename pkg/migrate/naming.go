@@ -0,0 +1,96 @@
+package migrate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Naming schemes for committed migration files
+const (
+	// NamingTimestamp names migrations YYYYMMDDHHMMSS_name.sql
+	NamingTimestamp = "timestamp"
+	// NamingSequential names migrations 000042_name.sql
+	NamingSequential = "sequential"
+)
+
+// sequenceWidth is the number of digits in a sequential migration prefix
+const sequenceWidth = 6
+
+// timestampWidth is the number of digits in a timestamp migration prefix
+const timestampWidth = 14
+
+// ValidateNaming returns an error if naming is not a known naming scheme
+func ValidateNaming(naming string) error {
+	switch naming {
+	case "", NamingTimestamp, NamingSequential:
+		return nil
+	default:
+		return fmt.Errorf("unknown migration naming scheme %q (use %q or %q)", naming, NamingTimestamp, NamingSequential)
+	}
+}
+
+// Slugify turns a migration name into a safe file name component.
+// Surrounding whitespace is trimmed and inner whitespace becomes underscores;
+// anything other than ASCII letters, digits, '_' and '-' is rejected.
+func Slugify(name string) (string, error) {
+	slug := strings.Join(strings.Fields(name), "_")
+	if slug == "" {
+		return "", fmt.Errorf("migration name is empty")
+	}
+
+	for _, r := range slug {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return "", fmt.Errorf("invalid migration name %q: %q is not allowed (use letters, digits, spaces, '_' or '-')", name, r)
+		}
+	}
+
+	return slug, nil
+}
+
+// nextFileName returns the file name for a new migration, given the existing migration files
+func (m *Migrator) nextFileName(name string, files []string) (string, error) {
+	slug, err := Slugify(name)
+	if err != nil {
+		return "", err
+	}
+
+	var prefix string
+	switch m.Naming {
+	case "", NamingTimestamp:
+		prefix = time.Now().UTC().Format("20060102150405")
+	case NamingSequential:
+		last := 0
+		for _, file := range files {
+			digits := numericPrefix(file)
+			if len(digits) == timestampWidth {
+				return "", fmt.Errorf("cannot use sequential naming: %s uses a timestamp and would sort after new migrations", file)
+			}
+			if n, err := strconv.Atoi(digits); err == nil && n > last {
+				last = n
+			}
+		}
+		prefix = fmt.Sprintf("%0*d", sequenceWidth, last+1)
+	default:
+		return "", ValidateNaming(m.Naming)
+	}
+
+	// Two commits in the same second (or a hand-made file) must not share a prefix
+	for _, file := range files {
+		if numericPrefix(file) == prefix {
+			return "", fmt.Errorf("migration %s already uses the prefix %s; wait a moment and commit again", file, prefix)
+		}
+	}
+
+	return fmt.Sprintf("%s_%s.sql", prefix, slug), nil
+}
+
+// numericPrefix returns the leading digits of a migration file name
+func numericPrefix(file string) string {
+	end := 0
+	for end < len(file) && file[end] >= '0' && file[end] <= '9' {
+		end++
+	}
+	return file[:end]
+}
@@ -0,0 +1,108 @@
+package migrate
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr string
+	}{
+		{name: "plain", input: "add_users", want: "add_users"},
+		{name: "spaces", input: "  add   users table ", want: "add_users_table"},
+		{name: "dashes and digits", input: "v2-add-users", want: "v2-add-users"},
+		{name: "empty", input: "   ", wantErr: "migration name is empty"},
+		{name: "path separator", input: "../etc/passwd", wantErr: "is not allowed"},
+		{name: "dot", input: "add.users", wantErr: "is not allowed"},
+		{name: "non-ASCII", input: "añadir", wantErr: "is not allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slug, err := Slugify(tt.input)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Slugify(%q) error = %v, want %q", tt.input, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Slugify(%q) error = %v", tt.input, err)
+			}
+			if slug != tt.want {
+				t.Errorf("Slugify(%q) = %q, want %q", tt.input, slug, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextFileNameSequential(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []string
+		want    string
+		wantErr string
+	}{
+		{name: "first", want: "000001_add_users.sql"},
+		{name: "after the highest", files: []string{"000001_a.sql", "000007_b.sql", "000003_c.sql"}, want: "000008_add_users.sql"},
+		{name: "ignores unnumbered files", files: []string{"seed.sql", "000002_a.sql"}, want: "000003_add_users.sql"},
+		{name: "timestamped files", files: []string{"20240101120000_a.sql"}, wantErr: "uses a timestamp"},
+	}
+
+	m := &Migrator{Naming: NamingSequential}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileName, err := m.nextFileName("add users", tt.files)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("nextFileName() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("nextFileName() error = %v", err)
+			}
+			if fileName != tt.want {
+				t.Errorf("nextFileName() = %q, want %q", fileName, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextFileNameTimestampCollision(t *testing.T) {
+	// Cover the current and the next second, so the test holds across a second boundary
+	now := time.Now().UTC()
+	files := []string{
+		now.Format("20060102150405") + "_a.sql",
+		now.Add(time.Second).Format("20060102150405") + "_b.sql",
+	}
+
+	m := &Migrator{Naming: NamingTimestamp}
+	if _, err := m.nextFileName("add users", files); err == nil || !strings.Contains(err.Error(), "already uses the prefix") {
+		t.Fatalf("nextFileName() error = %v, want a prefix collision", err)
+	}
+
+	fileName, err := m.nextFileName("add users", []string{"20000101000000_old.sql"})
+	if err != nil {
+		t.Fatalf("nextFileName() error = %v", err)
+	}
+	if len(numericPrefix(fileName)) != timestampWidth || !strings.HasSuffix(fileName, "_add_users.sql") {
+		t.Errorf("nextFileName() = %q, want a timestamped name", fileName)
+	}
+}
+
+func TestValidateNaming(t *testing.T) {
+	for _, naming := range []string{"", NamingTimestamp, NamingSequential} {
+		if err := ValidateNaming(naming); err != nil {
+			t.Errorf("ValidateNaming(%q) error = %v", naming, err)
+		}
+	}
+	if err := ValidateNaming("semver"); err == nil {
+		t.Error("ValidateNaming(semver) succeeded, want an error")
+	}
+}
@@ -0,0 +1,64 @@
+package migrate
+
+import "github.com/techtonic-org/rf-migrate/pkg/db"
+
+// Observer is notified of migration lifecycle events, for example to post a
+// message or fail a readiness check. Hooks run synchronously on the goroutine
+// doing the work, so they should return quickly. Embed NopObserver to
+// implement only some of the hooks.
+//
+// Every OnMigrationStart is followed by exactly one OnMigrationApplied,
+// OnMigrationFailed or OnCommit for the same file, and OnMigrationFailed is
+// never called without a preceding OnMigrationStart.
+type Observer interface {
+	// OnMigrationStart is called before Migrate checks the requirements of a
+	// migration file and applies it, and before Commit applies current.sql
+	OnMigrationStart(file string)
+
+	// OnMigrationApplied is called after Migrate has applied and recorded a migration
+	OnMigrationApplied(migration db.Migration)
+
+	// OnMigrationFailed is called when Migrate or Commit fails to apply a migration,
+	// when a requirement of the migration is not applied, or when Commit fails to
+	// write the migration file after recording it
+	OnMigrationFailed(file string, err error)
+
+	// OnCurrentApplied is called after current.sql has been applied by Apply or Watch,
+	// with one result per target database
+	OnCurrentApplied(results []TargetResult)
+
+	// OnCommit is called after Commit has applied, recorded and written a new migration
+	OnCommit(migration db.Migration)
+}
+
+// NopObserver implements Observer with hooks that do nothing
+type NopObserver struct{}
+
+// OnMigrationStart does nothing
+func (NopObserver) OnMigrationStart(file string) {}
+
+// OnMigrationApplied does nothing
+func (NopObserver) OnMigrationApplied(migration db.Migration) {}
+
+// OnMigrationFailed does nothing
+func (NopObserver) OnMigrationFailed(file string, err error) {}
+
+// OnCurrentApplied does nothing
+func (NopObserver) OnCurrentApplied(results []TargetResult) {}
+
+// OnCommit does nothing
+func (NopObserver) OnCommit(migration db.Migration) {}
+
+// WithObserver adds an observer of migration lifecycle events; it can be given more than once
+func WithObserver(observer Observer) Option {
+	return func(m *Migrator) {
+		m.Observers = append(m.Observers, observer)
+	}
+}
+
+// notify calls hook for every observer
+func (m *Migrator) notify(hook func(Observer)) {
+	for _, observer := range m.Observers {
+		hook(observer)
+	}
+}
@@ -0,0 +1,113 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"time"
+
+	"github.com/techtonic-org/rf-migrate/pkg/db"
+)
+
+// Option configures a Migrator created with New
+type Option func(*Migrator)
+
+// WithDir reads and writes migrations in a directory on disk. This is what the
+// CLI uses and the only source that supports Watch.
+func WithDir(dir string) Option {
+	return func(m *Migrator) {
+		m.FS = DirFS(dir)
+		m.MigrationDir = dir
+	}
+}
+
+// WithFS reads migrations from any fs.FS, such as an embed.FS. Commit and
+// Uncommit additionally require fsys to implement WritableFS.
+// For a "//go:embed migrations" directive, pass fs.Sub(embedded, "migrations").
+func WithFS(fsys fs.FS) Option {
+	return func(m *Migrator) {
+		m.FS = fsys
+		m.MigrationDir = ""
+	}
+}
+
+// WithLogger sends events to logger instead of slog.Default()
+func WithLogger(logger Logger) Option {
+	return func(m *Migrator) {
+		m.Logger = logger
+	}
+}
+
+// WithNaming sets the naming scheme for committed migrations
+func WithNaming(naming string) Option {
+	return func(m *Migrator) {
+		m.Naming = naming
+	}
+}
+
+// WithToolVersion sets the version recorded with each applied migration
+func WithToolVersion(version string) Option {
+	return func(m *Migrator) {
+		m.ToolVersion = version
+	}
+}
+
+// WithDependencies looks up requirements on other migration sets with checker,
+// waiting up to wait for them to be applied
+func WithDependencies(checker DependencyChecker, wait time.Duration) Option {
+	return func(m *Migrator) {
+		m.Dependencies = checker
+		m.DependencyWait = wait
+	}
+}
+
+// WithContext stops Migrate from waiting for dependencies and running hooks once ctx is done.
+// Statements already running are cancelled through db.WithContext instead.
+func WithContext(ctx context.Context) Option {
+	return func(m *Migrator) {
+		m.Context = ctx
+	}
+}
+
+// WithPlaceholders substitutes values for ${name} placeholders in migration SQL
+func WithPlaceholders(placeholders map[string]string) Option {
+	return func(m *Migrator) {
+		m.Placeholders = placeholders
+	}
+}
+
+// WithHooks runs shell commands before and after committed migrations are applied
+func WithHooks(hooks Hooks) Option {
+	return func(m *Migrator) {
+		m.Hooks = hooks
+	}
+}
+
+// New creates a migrator for database and brings its bookkeeping tables up to date.
+// Migrations are read from ./migrations unless WithDir or WithFS is given.
+func New(database db.DB, opts ...Option) (*Migrator, error) {
+	if database == nil {
+		return nil, errors.New("database is required")
+	}
+
+	m := &Migrator{DB: database, Logger: slog.Default()}
+	WithDir("migrations")(m)
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	if m.Logger == nil {
+		m.Logger = slog.Default()
+	}
+	if err := ValidateNaming(m.Naming); err != nil {
+		return nil, err
+	}
+
+	// Ensure migrations table exists
+	if err := database.EnsureMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
@@ -0,0 +1,32 @@
+package migrate
+
+import (
+	"regexp"
+	"strings"
+)
+
+// placeholderPattern matches a placeholder such as ${schema} in migration SQL
+var placeholderPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expand substitutes m.Placeholders in migration SQL. Names are matched
+// case-insensitively, as config keys are lowercased when loaded. Placeholders
+// without a value are left as they are, so SQL that happens to contain ${...},
+// such as a function body, is not changed.
+func (m *Migrator) expand(content []byte) string {
+	if len(m.Placeholders) == 0 {
+		return string(content)
+	}
+
+	values := make(map[string]string, len(m.Placeholders))
+	for name, value := range m.Placeholders {
+		values[strings.ToLower(name)] = value
+	}
+
+	return placeholderPattern.ReplaceAllStringFunc(string(content), func(placeholder string) string {
+		name := placeholderPattern.FindStringSubmatch(placeholder)[1]
+		if value, ok := values[strings.ToLower(name)]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
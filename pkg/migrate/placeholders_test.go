@@ -0,0 +1,26 @@
+package migrate
+
+import "testing"
+
+func TestExpand(t *testing.T) {
+	m := &Migrator{Placeholders: map[string]string{"approle": "app_prod", "Schema": "billing"}}
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "no placeholders", content: "select 1;", want: "select 1;"},
+		{name: "substituted", content: "grant usage on schema ${schema} to ${appRole};", want: "grant usage on schema billing to app_prod;"},
+		{name: "case-insensitive", content: "${SCHEMA}.${Schema}", want: "billing.billing"},
+		{name: "undefined left alone", content: "select '${name}', $1, $$body$$;", want: "select '${name}', $1, $$body$$;"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.expand([]byte(tt.content)); got != tt.want {
+				t.Errorf("expand(%q) = %q, want %q", tt.content, got, tt.want)
+			}
+		})
+	}
+}
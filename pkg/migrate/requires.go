@@ -0,0 +1,176 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Headers are read from a migration's leading comments, e.g.
+//
+//	--! requires: accounts/20240101120000_create_accounts.sql
+//	--! no-transaction
+const (
+	requiresHeader      = "--! requires:"      // Migrations that must be applied first
+	noTransactionHeader = "--! no-transaction" // Run outside the record transaction, see db.Migration
+)
+
+// dependencyPollInterval is how often unmet dependencies are rechecked while waiting
+const dependencyPollInterval = 2 * time.Second
+
+// DependencyChecker reports whether a migration of another migration set has been applied
+type DependencyChecker interface {
+	IsApplied(set string, fileName string) (bool, error)
+}
+
+// Requirement is a migration that must be applied before the migration declaring it
+type Requirement struct {
+	Set      string // Empty for a migration of the same set
+	FileName string
+}
+
+// String returns the requirement as written in the header
+func (r Requirement) String() string {
+	if r.Set == "" {
+		return r.FileName
+	}
+	return r.Set + "/" + r.FileName
+}
+
+// parseRequirements reads the requires headers from the leading comment block of a migration.
+// Several requirements may be given on one line, separated by commas.
+func parseRequirements(content []byte) ([]Requirement, error) {
+	var requirements []Requirement
+
+	headers, err := leadingComments(content)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range headers {
+		if !strings.HasPrefix(line, requiresHeader) {
+			continue
+		}
+
+		for _, item := range strings.Split(strings.TrimPrefix(line, requiresHeader), ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+
+			var requirement Requirement
+			if set, file, ok := strings.Cut(item, "/"); ok {
+				requirement = Requirement{Set: strings.ToLower(set), FileName: file} // Set names are case-insensitive
+			} else {
+				requirement = Requirement{FileName: item}
+			}
+			if requirement.FileName == "" || strings.Contains(requirement.FileName, "/") {
+				return nil, fmt.Errorf("invalid requirement %q: use set/file.sql or file.sql", item)
+			}
+			requirements = append(requirements, requirement)
+		}
+	}
+
+	return requirements, nil
+}
+
+// parseNoTransaction reports whether a migration's leading comments ask for it
+// to run outside the transaction that records it
+func parseNoTransaction(content []byte) (bool, error) {
+	headers, err := leadingComments(content)
+	if err != nil {
+		return false, err
+	}
+	for _, line := range headers {
+		if line == noTransactionHeader {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// leadingComments returns the trimmed comment lines before the first statement of a migration
+func leadingComments(content []byte) ([]string, error) {
+	var comments []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			break // Headers end with the first statement
+		}
+		comments = append(comments, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read headers: %w", err)
+	}
+
+	return comments, nil
+}
+
+// sleep waits for d, or returns early with the error of m.Context once it is done
+func (m *Migrator) sleep(d time.Duration) error {
+	ctx := m.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// checkRequirements verifies that every requirement of a migration is applied.
+// Requirements of the same set must already be in appliedFiles; those of other sets are
+// looked up with m.Dependencies, waiting up to m.DependencyWait for them to be applied.
+func (m *Migrator) checkRequirements(file string, content []byte, appliedFiles map[string]bool) error {
+	requirements, err := parseRequirements(content)
+	if err != nil {
+		return fmt.Errorf("migration %s: %w", file, err)
+	}
+
+	for _, requirement := range requirements {
+		if requirement.Set == "" {
+			if !appliedFiles[requirement.FileName] {
+				return fmt.Errorf("migration %s requires %s, which has not been applied; rename it so it sorts after its requirement", file, requirement)
+			}
+			continue
+		}
+
+		if m.Dependencies == nil {
+			return fmt.Errorf("migration %s requires %s, but no other migration sets are configured", file, requirement)
+		}
+
+		deadline := time.Now().Add(m.DependencyWait)
+		for {
+			applied, err := m.Dependencies.IsApplied(requirement.Set, requirement.FileName)
+			if err != nil {
+				return fmt.Errorf("failed to check requirement %s of migration %s: %w", requirement, file, err)
+			}
+			if applied {
+				break
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("migration %s requires %s, which has not been applied", file, requirement)
+			}
+
+			m.Logger.Info("Waiting for required migration", "file", file, "requires", requirement.String())
+			if err := m.sleep(dependencyPollInterval); err != nil {
+				return fmt.Errorf("stopped waiting for requirement %s of migration %s: %w", requirement, file, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRequirements(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []Requirement
+		wantErr string
+	}{
+		{
+			name:    "no headers",
+			content: "create table t (id int);\n",
+		},
+		{
+			name:    "same set",
+			content: "--! requires: 20240101120000_create_users.sql\ncreate table t (id int);\n",
+			want:    []Requirement{{FileName: "20240101120000_create_users.sql"}},
+		},
+		{
+			name:    "other set, lowercased",
+			content: "--! requires: Accounts/20240101120000_create_accounts.sql\n",
+			want:    []Requirement{{Set: "accounts", FileName: "20240101120000_create_accounts.sql"}},
+		},
+		{
+			name:    "several on one line and across lines",
+			content: "-- Adds invoices\n--! requires: a.sql, accounts/b.sql\n\n--! requires: c.sql\nselect 1;\n",
+			want: []Requirement{
+				{FileName: "a.sql"},
+				{Set: "accounts", FileName: "b.sql"},
+				{FileName: "c.sql"},
+			},
+		},
+		{
+			name:    "headers end at the first statement",
+			content: "select 1;\n--! requires: a.sql\n",
+		},
+		{
+			name:    "missing file",
+			content: "--! requires: accounts/\n",
+			wantErr: `invalid requirement "accounts/"`,
+		},
+		{
+			name:    "nested path",
+			content: "--! requires: accounts/sub/a.sql\n",
+			wantErr: `invalid requirement "accounts/sub/a.sql"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requirements, err := parseRequirements([]byte(tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseRequirements() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseRequirements() error = %v", err)
+			}
+			if !reflect.DeepEqual(requirements, tt.want) {
+				t.Errorf("parseRequirements() = %+v, want %+v", requirements, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNoTransaction(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{name: "no headers", content: "create index concurrently i on t (id);\n"},
+		{name: "header", content: "-- Index orders\n--! no-transaction\ncreate index concurrently i on t (id);\n", want: true},
+		{name: "next to requirements", content: "--! requires: a.sql\n  --! no-transaction  \nselect 1;\n", want: true},
+		{name: "after the first statement", content: "select 1;\n--! no-transaction\n"},
+		{name: "other comment", content: "-- no-transaction\nselect 1;\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseNoTransaction([]byte(tt.content))
+			if err != nil {
+				t.Fatalf("parseNoTransaction() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("parseNoTransaction() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// neverApplied is a DependencyChecker for which no migration is ever applied
+type neverApplied struct{}
+
+func (neverApplied) IsApplied(set string, fileName string) (bool, error) { return false, nil }
+
+func TestCheckRequirementsStopsWaitingWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	m := &Migrator{
+		Logger:         slog.New(slog.DiscardHandler),
+		Dependencies:   neverApplied{},
+		DependencyWait: time.Hour,
+		Context:        ctx,
+	}
+
+	start := time.Now()
+	err := m.checkRequirements("000001_b.sql", []byte("--! requires: accounts/a.sql\n"), nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("checkRequirements() error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("checkRequirements() took %s after cancellation", elapsed)
+	}
+}
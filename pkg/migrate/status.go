@@ -0,0 +1,92 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/techtonic-org/rf-migrate/pkg/db"
+)
+
+// Status describes the applied and pending migrations and the state of current.sql
+type Status struct {
+	Applied     []db.Migration
+	Pending     []string
+	CurrentSize int
+	CurrentHash string
+}
+
+// Status reports which migrations are applied, which are pending and what current.sql holds
+func (m *Migrator) Status() (*Status, error) {
+	if err := m.setDefaults(); err != nil {
+		return nil, err
+	}
+
+	applied, err := m.DB.GetAppliedMigrations()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get applied migrations: %w", err)
+	}
+
+	files, err := getFiles(m.FS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations directory: %w", err)
+	}
+
+	appliedFiles := make(map[string]bool)
+	for _, migration := range applied {
+		appliedFiles[migration.FileName] = true
+	}
+
+	status := &Status{Applied: applied}
+	for _, file := range files {
+		if !appliedFiles[file] {
+			status.Pending = append(status.Pending, file)
+		}
+	}
+
+	content, err := m.readCurrent()
+	if err != nil {
+		return nil, err
+	}
+	status.CurrentSize = len(content)
+	if len(content) > 0 {
+		status.CurrentHash = computeHash(content)
+	}
+
+	return status, nil
+}
+
+// SchemaDiff compares the primary database schema from before the last reapply with
+// the schema as it is now, returning the objects that were added and removed
+func (m *Migrator) SchemaDiff() (added []string, removed []string, err error) {
+	if err := m.setDefaults(); err != nil {
+		return nil, nil, err
+	}
+
+	if m.lastSchema == nil {
+		return nil, nil, errors.New("no schema snapshot yet: current.sql has not been applied")
+	}
+
+	current, err := m.DB.DescribeSchema()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	before := make(map[string]bool, len(m.lastSchema))
+	for _, line := range m.lastSchema {
+		before[line] = true
+	}
+	after := make(map[string]bool, len(current))
+	for _, line := range current {
+		after[line] = true
+		if !before[line] {
+			added = append(added, line)
+		}
+	}
+	for _, line := range m.lastSchema {
+		if !after[line] {
+			removed = append(removed, line)
+		}
+	}
+
+	return added, removed, nil
+}
@@ -0,0 +1,89 @@
+package migrate
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/techtonic-org/rf-migrate/pkg/db"
+)
+
+// Target is a database that current.sql is applied to
+type Target struct {
+	Name string
+	DB   db.DB
+}
+
+// TargetResult is the outcome of applying current.sql to a single target
+type TargetResult struct {
+	Target   string
+	Duration time.Duration
+	Err      error
+}
+
+// ApplyTargets applies the current SQL migration file to all targets concurrently.
+// The returned results are in the same order as the targets. Observers are
+// notified unless current.sql is empty.
+func (m *Migrator) ApplyTargets(targets []Target) ([]TargetResult, error) {
+	if err := m.setDefaults(); err != nil {
+		return nil, err
+	}
+
+	content, err := m.readCurrent()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]TargetResult, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target Target) {
+			defer wg.Done()
+
+			start := time.Now()
+			var err error
+			if len(content) > 0 {
+				err = target.DB.Execute(m.expand(content))
+			}
+			results[i] = TargetResult{
+				Target:   target.Name,
+				Duration: time.Since(start),
+				Err:      err,
+			}
+		}(i, target)
+	}
+	wg.Wait()
+
+	if len(content) > 0 {
+		m.notify(func(o Observer) { o.OnCurrentApplied(results) })
+	}
+
+	return results, nil
+}
+
+// LogResults logs a compact per-target summary of an apply
+func (m *Migrator) LogResults(results []TargetResult) {
+	m.setDefaults() //nolint:errcheck // Only the logger is needed
+	for _, result := range results {
+		if result.Err != nil {
+			m.Logger.Error("Failed to apply current.sql", "target", result.Target, "duration", result.Duration, "error", result.Err)
+		} else {
+			m.Logger.Info("Applied current.sql", "target", result.Target, "duration", result.Duration)
+		}
+	}
+}
+
+// FailedResults returns an error describing how many targets failed, or nil if all succeeded
+func FailedResults(results []TargetResult) error {
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	if failed == 0 {
+		return nil
+	}
+	return fmt.Errorf("failed to apply current.sql to %d of %d databases", failed, len(results))
+}